@@ -0,0 +1,407 @@
+// Package breaker provides circuit breakers for calls to the dependencies a
+// service relies on, so once a dependency starts failing, calls to it fail fast
+// rather than piling up behind timeouts, and it gets a chance to recover:
+//
+//	breakers := breaker.NewSet()
+//	b := breakers.Get("inventory")
+//	err := b.Do(ctx, func(ctx context.Context) error {
+//		return inventory.Reserve(ctx, item)
+//	})
+//	if errors.Is(err, breaker.ErrOpen) {
+//		// answer without the inventory service
+//	}
+//
+// A breaker starts closed, letting every call through. Once enough calls in a
+// row fail, it opens, and calls fail with ErrOpen without being made. After a
+// while it turns half-open and lets a few probe calls through: if they
+// succeed it closes again, and if one fails it opens again.
+//
+// A Breaker is a health.Checker that fails while it is open, so registering it
+// with the daemon's health registry takes the instance out of rotation while
+// the dependency is down. Registering it with health.Informational instead
+// only reports it, for dependencies the service can answer without:
+//
+//	d.Health().Register("inventory breaker", b, health.Informational())
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/metrics"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
+	defaultHalfOpenProbes   = 1
+)
+
+// ErrOpen is returned for calls a breaker turns away without making them.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a breaker.
+type State int
+
+const (
+	// StateClosed lets every call through.
+	StateClosed State = iota
+	// StateOpen turns every call away.
+	StateOpen
+	// StateHalfOpen lets a few probe calls through to see whether the
+	// dependency has recovered.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Option configures a Breaker.
+type Option func(*config)
+
+type config struct {
+	failureThreshold int
+	openTimeout      time.Duration
+	halfOpenProbes   int
+	isFailure        func(error) bool
+	onStateChange    func(name string, from, to State)
+}
+
+// FailureThreshold sets how many calls in a row have to fail for the breaker to
+// open, 5 by default.
+func FailureThreshold(n int) Option {
+	return func(c *config) {
+		c.failureThreshold = max(n, 1)
+	}
+}
+
+// OpenTimeout sets how long the breaker stays open before letting probe calls
+// through, 30 seconds by default.
+func OpenTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.openTimeout = d
+	}
+}
+
+// HalfOpenProbes sets how many probe calls the breaker lets through while
+// half-open, all of which have to succeed for it to close, 1 by default.
+func HalfOpenProbes(n int) Option {
+	return func(c *config) {
+		c.halfOpenProbes = max(n, 1)
+	}
+}
+
+// IsFailure sets which errors count as failures of the dependency. By default
+// every error does, except context.Canceled, since the caller giving up says
+// nothing about the dependency. Errors that aren't failures, such as a record
+// not being found, count as successes.
+func IsFailure(fn func(err error) bool) Option {
+	return func(c *config) {
+		c.isFailure = fn
+	}
+}
+
+// OnStateChange calls fn whenever the breaker changes state, e.g. to log it.
+// fn is called with the breaker's lock held, so it mustn't call the breaker.
+func OnStateChange(fn func(name string, from, to State)) Option {
+	return func(c *config) {
+		c.onStateChange = fn
+	}
+}
+
+func newConfig(opts []Option) config {
+	c := config{
+		failureThreshold: defaultFailureThreshold,
+		openTimeout:      defaultOpenTimeout,
+		halfOpenProbes:   defaultHalfOpenProbes,
+		isFailure: func(err error) bool {
+			return !errors.Is(err, context.Canceled)
+		},
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// Breaker is a circuit breaker for one dependency. It is safe for concurrent
+// use.
+type Breaker struct {
+	name string
+	config
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	lastErr  error
+	// probes counts the probe calls let through while half-open, and
+	// succeeded those that have succeeded
+	probes    int
+	succeeded int
+	stats     Stats
+}
+
+// Stats counts the calls a breaker has seen.
+type Stats struct {
+	Name  string
+	State State
+	// Successes and Failures count the calls made, and Rejected those turned
+	// away with ErrOpen.
+	Successes uint64
+	Failures  uint64
+	Rejected  uint64
+	// Opened counts how many times the breaker has opened.
+	Opened uint64
+}
+
+// New returns a closed breaker for the dependency called name.
+func New(name string, opts ...Option) *Breaker {
+	return &Breaker{name: name, config: newConfig(opts)}
+}
+
+// Name returns the name of the breaker's dependency.
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// Do calls fn unless the breaker is open, in which case it returns ErrOpen,
+// and records whether fn failed.
+func (b *Breaker) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn(ctx)
+	done(err)
+	return err
+}
+
+// Allow reports whether a call may be made, returning ErrOpen if not, for
+// callers that can't wrap the call in a function for Do. If it may, done must
+// be called with the call's error once it has been made.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.openTimeout {
+		b.setState(StateHalfOpen)
+	}
+	switch {
+	case b.state == StateOpen,
+		b.state == StateHalfOpen && b.probes >= b.halfOpenProbes:
+		b.stats.Rejected++
+		return nil, ErrOpen
+	case b.state == StateHalfOpen:
+		b.probes++
+	}
+	state := b.state
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { b.record(state, err) })
+	}, nil
+}
+
+// record counts the outcome of a call let through while the breaker was in
+// state.
+func (b *Breaker) record(state State, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failed := err != nil && b.isFailure(err)
+	if failed {
+		b.stats.Failures++
+		b.lastErr = err
+	} else {
+		b.stats.Successes++
+	}
+	// a call that started before the breaker last changed state says nothing
+	// about the dependency's current state, beyond being counted
+	if state != b.state {
+		return
+	}
+
+	switch b.state {
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			b.open()
+		}
+	case StateHalfOpen:
+		if failed {
+			b.open()
+			return
+		}
+		b.succeeded++
+		if b.succeeded >= b.halfOpenProbes {
+			b.setState(StateClosed)
+		}
+	}
+}
+
+// open opens the breaker. It must be called with mu held.
+func (b *Breaker) open() {
+	b.openedAt = time.Now()
+	b.stats.Opened++
+	b.setState(StateOpen)
+}
+
+// setState moves the breaker to state, resetting its counts. It must be called
+// with mu held.
+func (b *Breaker) setState(state State) {
+	from := b.state
+	b.state = state
+	b.failures, b.probes, b.succeeded = 0, 0, 0
+	if b.onStateChange != nil && from != state {
+		b.onStateChange(b.name, from, state)
+	}
+}
+
+// State returns the breaker's state. An open breaker reports StateHalfOpen
+// once its open timeout has passed, even before the next call.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.openTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// Stats returns the counts of the calls the breaker has seen.
+func (b *Breaker) Stats() Stats {
+	state := b.State()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.stats
+	s.Name = b.name
+	s.State = state
+	return s
+}
+
+// Check implements health.Checker, failing while the breaker is open with the
+// error that last tripped it.
+func (b *Breaker) Check(ctx context.Context) error {
+	if b.State() != StateOpen {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return fmt.Errorf("%w: %w", ErrOpen, b.lastErr)
+}
+
+// Details implements health.DetailedChecker.
+func (b *Breaker) Details() map[string]any {
+	s := b.Stats()
+	return map[string]any{
+		"state":     s.State.String(),
+		"successes": s.Successes,
+		"failures":  s.Failures,
+		"rejected":  s.Rejected,
+		"opened":    s.Opened,
+	}
+}
+
+// Set holds a breaker for each of a service's dependencies, made on first use
+// with the same options.
+type Set struct {
+	opts []Option
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewSet returns an empty Set whose breakers are made with opts.
+func NewSet(opts ...Option) *Set {
+	return &Set{opts: opts, breakers: make(map[string]*Breaker)}
+}
+
+// Get returns the breaker for the dependency called name, making it if it
+// doesn't exist yet.
+func (s *Set) Get(name string) *Breaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.breakers[name]
+	if !ok {
+		b = New(name, s.opts...)
+		s.breakers[name] = b
+	}
+	return b
+}
+
+// Stats returns the stats of every breaker in the set, sorted by name.
+func (s *Set) Stats() []Stats {
+	s.mu.Lock()
+	breakers := make([]*Breaker, 0, len(s.breakers))
+	for _, b := range s.breakers {
+		breakers = append(breakers, b)
+	}
+	s.mu.Unlock()
+
+	stats := make([]Stats, 0, len(breakers))
+	for _, b := range breakers {
+		stats = append(stats, b.Stats())
+	}
+	slices.SortFunc(stats, func(a, b Stats) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return stats
+}
+
+// MetricsHandler returns an http.Handler that serves the stats of every
+// breaker in the set in the Prometheus text format, as a circuit_breaker_state
+// gauge that is 0 while closed, 1 while open and 2 while half-open, and
+// circuit_breaker_calls_total and circuit_breaker_opened_total counters.
+func (s *Set) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes the metrics MetricsHandler serves to w, which makes the
+// set a metrics.Collector.
+func (s *Set) WriteMetrics(w io.Writer) {
+	all := s.Stats()
+
+	fmt.Fprintln(w, "# HELP circuit_breaker_state The state of each circuit breaker: 0 closed, 1 open, 2 half-open.")
+	fmt.Fprintln(w, "# TYPE circuit_breaker_state gauge")
+	for _, st := range all {
+		fmt.Fprintf(w, "circuit_breaker_state{breaker=%s} %d\n", metrics.QuoteLabel(st.Name), st.State)
+	}
+
+	fmt.Fprintln(w, "# HELP circuit_breaker_calls_total Calls through each circuit breaker by result.")
+	fmt.Fprintln(w, "# TYPE circuit_breaker_calls_total counter")
+	for _, st := range all {
+		name := metrics.QuoteLabel(st.Name)
+		fmt.Fprintf(w, "circuit_breaker_calls_total{breaker=%s,result=\"success\"} %d\n", name, st.Successes)
+		fmt.Fprintf(w, "circuit_breaker_calls_total{breaker=%s,result=\"failure\"} %d\n", name, st.Failures)
+		fmt.Fprintf(w, "circuit_breaker_calls_total{breaker=%s,result=\"rejected\"} %d\n", name, st.Rejected)
+	}
+
+	fmt.Fprintln(w, "# HELP circuit_breaker_opened_total How many times each circuit breaker has opened.")
+	fmt.Fprintln(w, "# TYPE circuit_breaker_opened_total counter")
+	for _, st := range all {
+		fmt.Fprintf(w, "circuit_breaker_opened_total{breaker=%s} %d\n", metrics.QuoteLabel(st.Name), st.Opened)
+	}
+}
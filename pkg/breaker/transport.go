@@ -0,0 +1,40 @@
+package breaker
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Transport returns a RoundTripper that sends requests through the breaker
+// with rt, or http.DefaultTransport if rt is nil, for a dependency reached over
+// HTTP. Responses with a 5xx status count as failures, though they are still
+// returned as they are, and requests the breaker turns away fail with ErrOpen:
+//
+//	client := httpclient.New(httpclient.WithTransport(b.Transport(nil)))
+func (b *Breaker) Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		done, err := b.Allow()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := rt.RoundTrip(r)
+		switch {
+		case err != nil:
+			done(err)
+		case resp.StatusCode >= 500:
+			done(fmt.Errorf("%s %s: %s", r.Method, r.URL.Redacted(), resp.Status))
+		default:
+			done(nil)
+		}
+		return resp, err
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
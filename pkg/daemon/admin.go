@@ -0,0 +1,229 @@
+package daemon
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/health"
+)
+
+// WithAdminToken enables the admin endpoints on the internal server, which
+// require requests to carry token as a bearer token in the Authorization
+// header. Without a token they refuse every request, so nothing that can reach
+// the internal port can change how the daemon behaves.
+func WithAdminToken(token string) Option {
+	return func(d *Daemon) {
+		d.adminToken = token
+	}
+}
+
+// WithAdminHeader lets clients of the admin endpoints send the admin token as
+// the whole value of the named header, e.g. X-Admin-Token, instead of as a
+// bearer token, for tools that can set a header but not the Authorization one.
+func WithAdminHeader(name string) Option {
+	return func(d *Daemon) {
+		d.adminHeader = name
+	}
+}
+
+// RequireAdmin only lets requests carrying the admin token set with
+// WithAdminToken through to h, so endpoints the application serves itself that
+// change how it behaves, such as flushing a cache, can be protected the same way
+// as the daemon's. Like the daemon's admin endpoints, h refuses every request if
+// no token has been set.
+func (d *Daemon) RequireAdmin(h http.Handler) http.Handler {
+	return d.requireAdmin(h.ServeHTTP)
+}
+
+// requireAdmin only lets requests with the admin token through to h.
+func (d *Daemon) requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d.adminToken == "" {
+			http.Error(w, "admin endpoints are disabled", http.StatusForbidden)
+			return
+		}
+		if !d.isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// isAdmin reports whether r carries the admin token, either as a bearer token
+// or in the admin header.
+func (d *Daemon) isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && d.adminHeader != "" {
+		token, ok = r.Header.Get(d.adminHeader), true
+	}
+	// comparing hashes keeps the time taken from giving away the token's length
+	// as well as its contents
+	want := sha256.Sum256([]byte(d.adminToken))
+	got := sha256.Sum256([]byte(token))
+	return ok && subtle.ConstantTimeCompare(got[:], want[:]) == 1
+}
+
+// serveAdminReady reports whether the health registry has the instance in
+// rotation, and takes it out of or puts it back into rotation on PUT or POST
+// with ?ready=false or ?ready=true.
+func (d *Daemon) serveAdminReady(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		ready, err := strconv.ParseBool(r.URL.Query().Get("ready"))
+		if err != nil {
+			http.Error(w, "ready must be true or false", http.StatusBadRequest)
+			return
+		}
+		d.health.SetReady(ready)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"ready": d.health.Ready()})
+}
+
+// serveAdminChecks lists the readiness checks that are turned off, and turns
+// one off or back on on PUT or POST with ?name=db&enabled=false, optionally
+// with &for=30m to have it turn itself back on.
+func (d *Daemon) serveAdminChecks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		query := r.URL.Query()
+		enabled, err := strconv.ParseBool(query.Get("enabled"))
+		if err != nil {
+			http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+			return
+		}
+		var duration time.Duration
+		if v := query.Get("for"); v != "" {
+			if duration, err = time.ParseDuration(v); err != nil {
+				http.Error(w, "for must be a duration such as 30m", http.StatusBadRequest)
+				return
+			}
+		}
+		if enabled {
+			err = d.health.Enable(query.Get("name"))
+		} else {
+			err = d.health.Disable(query.Get("name"), duration)
+		}
+		if errors.Is(err, health.ErrUnknownCheck) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type disabledCheck struct {
+		Name  string    `json:"name"`
+		Until time.Time `json:"until,omitzero"`
+	}
+	disabled := []disabledCheck{}
+	for name, until := range d.health.Disabled() {
+		disabled = append(disabled, disabledCheck{Name: name, Until: until})
+	}
+	slices.SortFunc(disabled, func(a, b disabledCheck) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"disabled": disabled})
+}
+
+// serveAdminDrain reports the daemon's state, drains it on PUT or POST, as
+// Drain does, and resumes it on DELETE.
+func (d *Daemon) serveAdminDrain(w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		err = d.Drain()
+	case http.MethodDelete:
+		err = d.Resume()
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if errors.Is(err, ErrNotRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"state": d.State().String()})
+}
+
+// serveAdminShutdown starts a graceful shutdown on POST, with ErrAdminShutdown
+// as the cause, and responds without waiting for it to finish.
+func (d *Daemon) serveAdminShutdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d.requestStop(ErrAdminShutdown)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveAdminLogLevel reports the level set on LogLevel, and changes it on PUT
+// or POST with ?level=debug, or any other level slog can parse, such as
+// INFO+2.
+func (d *Daemon) serveAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(r.URL.Query().Get("level"))); err != nil {
+			http.Error(w, "level must be debug, info, warn or error", http.StatusBadRequest)
+			return
+		}
+		d.setLogLevel(level, "admin endpoint")
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"level": d.logLevel.Level().String()})
+}
+
+// serveAdminLoadShed reports the limit on requests in flight set with
+// WithLoadShedding, along with how many are in flight and how many have been
+// turned away, and changes it on PUT or POST with ?limit=200.
+func (d *Daemon) serveAdminLoadShed(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil {
+			http.Error(w, "limit must be a number", http.StatusBadRequest)
+			return
+		}
+		d.shedder.SetLimit(limit)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"limit":     d.shedder.Limit(),
+		"in_flight": d.shedder.InFlight(),
+		"shed":      d.shedder.Shed(),
+	})
+}
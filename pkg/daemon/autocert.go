@@ -0,0 +1,53 @@
+package daemon
+
+import (
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// WithAutocert makes the main server serve HTTPS with certificates obtained
+// and renewed from Let's Encrypt over ACME for hosts, caching them in
+// cacheDir so restarts don't request new ones. It takes precedence over
+// WithTLS.
+//
+// ACME proves control of a host with HTTP-01 challenges on port 80, so the
+// daemon also runs a server there, on the address set with
+// WithACMEChallengeAddr, that answers the challenges and redirects everything
+// else to HTTPS. Autocert returns the manager to set e.g. a contact email or a
+// staging directory before Run.
+func WithAutocert(cacheDir string, hosts ...string) Option {
+	return func(d *Daemon) {
+		d.autocert = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cacheDir),
+			HostPolicy: autocert.HostWhitelist(hosts...),
+		}
+	}
+}
+
+// WithACMEChallengeAddr sets the address of the server answering ACME HTTP-01
+// challenges for WithAutocert. It defaults to ":http".
+func WithACMEChallengeAddr(addr string) Option {
+	return func(d *Daemon) {
+		d.acmeChallengeAddr = addr
+	}
+}
+
+// Autocert returns the ACME certificate manager set up by WithAutocert, or nil
+// if there isn't one.
+func (d *Daemon) Autocert() *autocert.Manager {
+	return d.autocert
+}
+
+// setupAutocert adds the server answering ACME challenges if WithAutocert was
+// used.
+func (d *Daemon) setupAutocert() {
+	if d.autocert == nil {
+		return
+	}
+	d.AddServer("acme challenge", &http.Server{
+		Addr:    d.acmeChallengeAddr,
+		Handler: d.autocert.HTTPHandler(nil),
+	})
+}
@@ -0,0 +1,38 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// ErrShutdownRequested is the cause of a shutdown started by calling Shutdown.
+var ErrShutdownRequested = errors.New("shutdown requested")
+
+// ErrAdminShutdown is the cause of a shutdown started through the
+// /admin/shutdown endpoint.
+var ErrAdminShutdown = errors.New("shutdown requested through the admin endpoint")
+
+// SignalError is the cause of a shutdown started by an OS signal.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e *SignalError) Error() string {
+	return "received signal " + e.Signal.String()
+}
+
+type causeKey struct{}
+
+// ShutdownCause reports why the daemon is shutting down: a *SignalError,
+// ErrShutdownRequested, ErrAdminShutdown, ErrUpgraded, the failure of a service
+// or supervised goroutine, or the cause of the context passed to Run being
+// canceled. It works with request contexts once the root context has been
+// canceled, and with the contexts passed to services and hooks while they are
+// stopping. It returns nil if ctx has nothing to do with a shutdown.
+func ShutdownCause(ctx context.Context) error {
+	if cause, ok := ctx.Value(causeKey{}).(error); ok {
+		return cause
+	}
+	return context.Cause(ctx)
+}
@@ -0,0 +1,148 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainLogInterval is how often the daemon reports the connections it is
+// still waiting on while draining.
+const drainLogInterval = time.Second
+
+// ConnStats counts the client connections open on the daemon's HTTP servers,
+// other than the internal server.
+type ConnStats struct {
+	// Active is the number of connections in the middle of a request.
+	Active int
+	// Idle is the number of connections waiting for their next request,
+	// including new ones that haven't sent a request yet.
+	Idle int
+	// WebSockets is the number of connections registered with AddWebSocket.
+	WebSockets int
+	// Rejected is how many connections have been turned away since the daemon
+	// started, because a server already had as many as MaxConns allows.
+	Rejected int64
+}
+
+// Connections returns the number of active and idle connections on the main
+// server and the servers added with AddServer. Connections taken over by a
+// handler are only counted if they were registered with AddWebSocket.
+func (d *Daemon) Connections() ConnStats {
+	s := d.conns.stats()
+	s.WebSockets = d.openWebSockets()
+	return s
+}
+
+// WithMaxShutdownTimeout lets the drain run past the shutdown timeout, up to
+// max, for as long as connections keep finishing their requests. Once the
+// shutdown timeout has passed, the daemon gives up as soon as a second goes by
+// without the number of active connections dropping, rather than cutting off
+// requests that were about to finish. By default the drain ends at the
+// shutdown timeout.
+func WithMaxShutdownTimeout(max time.Duration) Option {
+	return func(d *Daemon) {
+		d.maxShutdownTimeout = max
+	}
+}
+
+// connTracker follows the state of every connection on the servers it is
+// attached to.
+type connTracker struct {
+	mu       sync.Mutex
+	conns    map[net.Conn]http.ConnState
+	rejected atomic.Int64
+}
+
+// track makes the tracker follow the connections of s, calling the ConnState
+// hook s already had as before.
+func (t *connTracker) track(s *http.Server) {
+	next := s.ConnState
+	s.ConnState = func(c net.Conn, state http.ConnState) {
+		t.set(c, state)
+		if next != nil {
+			next(c, state)
+		}
+	}
+}
+
+func (t *connTracker) set(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(t.conns, c)
+	default:
+		if t.conns == nil {
+			t.conns = make(map[net.Conn]http.ConnState)
+		}
+		t.conns[c] = state
+	}
+}
+
+// listenOption counts the connections rejected by MaxConns.
+func (t *connTracker) listenOption(c *listenConfig) {
+	c.onReject = func() {
+		t.rejected.Add(1)
+	}
+}
+
+func (t *connTracker) stats() ConnStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := ConnStats{Rejected: t.rejected.Load()}
+	for _, state := range t.conns {
+		if state == http.StateActive {
+			s.Active++
+		} else {
+			s.Idle++
+		}
+	}
+	return s
+}
+
+// drainContext returns the context services are stopped with, whose cause is
+// ErrShutdownTimeout once the drain has run out of time. Its deadline is the
+// longest the drain may take, so services planning around it don't give up
+// early.
+func (d *Daemon) drainContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	drainCtx, cancelTimeout := context.WithTimeoutCause(ctx, max(d.shutdownTimeout, d.maxShutdownTimeout), ErrShutdownTimeout)
+	drainCtx, cancel := context.WithCancelCause(drainCtx)
+	go d.watchDrain(drainCtx, cancel)
+	return drainCtx, func() {
+		cancel(nil)
+		cancelTimeout()
+	}
+}
+
+// watchDrain reports the connections still active every second until ctx is
+// done. If the drain may run past the shutdown timeout, it ends it with cancel
+// once that has passed and a second has gone by without any connection
+// finishing its request.
+func (d *Daemon) watchDrain(ctx context.Context, cancel context.CancelCauseFunc) {
+	deadline := time.Now().Add(d.shutdownTimeout)
+	ticker := time.NewTicker(drainLogInterval)
+	defer ticker.Stop()
+	stats := d.Connections()
+	last := stats.Active + stats.WebSockets
+	for {
+		select {
+		case now := <-ticker.C:
+			stats := d.Connections()
+			active := stats.Active + stats.WebSockets
+			if active > 0 {
+				d.logger.Info("waiting on active connections", "connections", stats.Active, "websockets", stats.WebSockets)
+			}
+			if d.maxShutdownTimeout > d.shutdownTimeout && !now.Before(deadline) && active >= last {
+				cancel(ErrShutdownTimeout)
+				return
+			}
+			last = active
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,693 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/forgeutah/utah-go/pkg/health"
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+	"github.com/forgeutah/utah-go/pkg/metrics"
+)
+
+const (
+	defaultRouteTimeout    = 5 * time.Second
+	defaultShutdownTimeout = 10 * time.Second
+	defaultCancelWait      = 3 * time.Second
+)
+
+type versionKey struct{}
+
+// Version returns the application version seeded into the daemon's root
+// context. See WithVersion.
+func Version(ctx context.Context) string {
+	v, _ := ctx.Value(versionKey{}).(string)
+	return v
+}
+
+// Daemon serves a handler on a main server and health checks on a separate
+// internal server, along with any other servers and services added to it, and
+// shuts them all down cleanly when it receives a shutdown signal.
+type Daemon struct {
+	handler http.Handler
+
+	addr               string
+	internalAddr       string
+	listenOpts         []ListenOption
+	middleware         []httpmw.Middleware
+	accessLog          httpmw.Middleware
+	shedder            *httpmw.ConcurrencyLimiter
+	drainRetryAfter    time.Duration
+	h2c                bool
+	http3              bool
+	timeouts           serverTimeouts
+	bindRetry          time.Duration
+	version            string
+	routeTimeout       time.Duration
+	routeTimeouts      []httpmw.RouteTimeout
+	shutdownTimeout    time.Duration
+	maxShutdownTimeout time.Duration
+	preShutdownDelay   time.Duration
+	cancelWait         time.Duration
+	shutdownSignals    []os.Signal
+	health             *health.Registry
+	liveness           *health.Registry
+	connContext        func(ctx context.Context, c net.Conn) context.Context
+	adminToken         string
+	adminHeader        string
+	pprof              bool
+	expvar             bool
+	diagnostics        bool
+	tls                *CertReloader
+	tlsWatchInterval   time.Duration
+	autocert           *autocert.Manager
+	acmeChallengeAddr  string
+
+	internalTLS              *CertReloader
+	internalTLSWatchInterval time.Duration
+	internalClientCAFile     string
+	internalAllow            []string
+	internalDeny             []string
+
+	servicesMu sync.Mutex
+	services   []namedService
+	addrs      map[string]net.Addr
+	hosts      map[string]http.Handler
+
+	supervisor supervisor
+
+	tasksMu        sync.Mutex
+	startupTasks   []startupTask
+	tasksRemaining int
+
+	signalsMu      sync.Mutex
+	signalHandlers map[os.Signal]func(ctx context.Context)
+	signalDebounce time.Duration
+	signalHistory  []SignalRecord
+
+	reloadMu  sync.Mutex
+	reloaders []namedReloader
+
+	upgradeMu sync.Mutex
+
+	// logLevel is the level the application logs at, changed through the admin
+	// endpoint
+	logLevel slog.LevelVar
+	logger   *slog.Logger
+	// levelMu serializes changes to logLevel, and levelBeforeDebug is the level
+	// to go back to when debug logging is toggled off
+	levelMu          sync.Mutex
+	levelBeforeDebug slog.Level
+
+	// conns tracks the connections on the servers other than the internal one
+	conns connTracker
+	// requests counts the requests being handled by the servers other than the
+	// internal one
+	requests atomic.Int64
+	// panics counts the requests whose handlers panicked
+	panics atomic.Int64
+	// requestMetrics records the requests to the servers other than the
+	// internal one
+	requestMetrics *httpmw.RequestMetrics
+	// metrics is the registry served at /metrics on the internal server
+	metrics *metrics.Registry
+	// stopping is set once the servers start stopping, after the pre-shutdown
+	// delay
+	stopping atomic.Bool
+	// draining mirrors whether the state is StateDraining, for the servers to
+	// check on every request without taking stateMu
+	draining atomic.Bool
+	// lbDeadline is when the pre-shutdown delay ends, as Unix nanoseconds, once
+	// the daemon is shutting down
+	lbDeadline atomic.Int64
+
+	webSocketsMu      sync.Mutex
+	webSockets        map[*webSocket]struct{}
+	closingWebSockets bool
+
+	eventStreamsMu       sync.Mutex
+	eventStreams         map[*EventStream]struct{}
+	drainingEventStreams bool
+
+	// inflight counts requests being handled by the main server and background
+	// work tracked by the daemon, so shutdown can wait for them to return
+	inflight sync.WaitGroup
+
+	// startTime is when Run was called, for the status page's uptime
+	startTime time.Time
+
+	// fatal receives the first error that should make the daemon shut down,
+	// such as a service failing after it started
+	fatal chan error
+
+	// stop is closed by Shutdown to ask Run to stop because of stopCause, and
+	// done is closed once Run has returned runErr
+	stop      chan struct{}
+	stopOnce  sync.Once
+	stopCause error
+	done      chan struct{}
+	runErr    error
+
+	hooksMu       sync.Mutex
+	startupHooks  []hook
+	shutdownHooks []hook
+
+	// state backs the readiness check, which only passes while the daemon is
+	// ready so load balancers stop sending requests here once it starts
+	// shutting down. notifyMu keeps observers seeing transitions in order
+	stateMu        sync.Mutex
+	notifyMu       sync.Mutex
+	state          State
+	startedUp      bool
+	shuttingDown   bool
+	stateObservers []func(from, to State)
+
+	eventsMu    sync.Mutex
+	subscribers []func(Event)
+}
+
+// New returns a Daemon that serves handler on its main server, configured by
+// opts. If handler is nil there is no main server unless HandleHost adds one,
+// and the daemon only runs the servers and services added to it. Addresses and
+// the version default to the APP_PORT, INTERNAL_PORT and APP_VERSION
+// environment variables.
+func New(handler http.Handler, opts ...Option) *Daemon {
+	d := &Daemon{
+		handler:           handler,
+		requestMetrics:    httpmw.NewRequestMetrics(),
+		addr:              ":" + os.Getenv("APP_PORT"),
+		internalAddr:      ":" + os.Getenv("INTERNAL_PORT"),
+		version:           os.Getenv("APP_VERSION"),
+		routeTimeout:      defaultRouteTimeout,
+		shutdownTimeout:   defaultShutdownTimeout,
+		cancelWait:        defaultCancelWait,
+		shutdownSignals:   defaultShutdownSignals,
+		signalDebounce:    defaultSignalDebounce,
+		acmeChallengeAddr: ":http",
+		health:            health.NewRegistry(),
+		liveness:          health.NewRegistry(),
+		metrics:           metrics.NewRegistry(),
+		fatal:             make(chan error, 1),
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+	if reloadSignal != nil {
+		d.HandleSignal(reloadSignal, func(ctx context.Context) {
+			d.Reload(ctx)
+		})
+	}
+	if debugSignal != nil {
+		d.HandleSignal(debugSignal, d.toggleDebug)
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.logger == nil {
+		d.logger = d.defaultLogger()
+	}
+	d.logHealthChanges(d.health, "readiness")
+	d.logHealthChanges(d.liveness, "liveness")
+	d.registerMetrics()
+	d.setupTLS()
+	d.setupAutocert()
+	d.setupInternalTLS()
+	d.publishExpvars()
+	// if an older process handed over its listeners, let it know once we're
+	// ready so it can shut down
+	inherit(d.logger)
+	d.OnStateChange(func(from, to State) {
+		if to == StateReady {
+			upgradeReady()
+		}
+	})
+	return d
+}
+
+// Run starts the internal server, runs the startup hooks, starts any added
+// services and the main server, and blocks until the process receives a shutdown
+// signal, ctx is done, Shutdown is called, or a service or supervised
+// goroutine fails. It then stops the services in reverse dependency order,
+// cancels all request contexts, runs the shutdown hooks, stops the startup
+// hooks and stops the internal server.
+//
+// If a startup hook or service fails to start, Run returns a *StartError after
+// undoing whatever had already started. Otherwise the error returned joins the
+// failure that caused the shutdown, if any, with the ones reported while
+// stopping services and running hooks, wrapping ErrShutdownTimeout if services
+// did not stop in time.
+//
+// The root context is canceled with the reason for shutting down as its cause,
+// which ShutdownCause reports. If another signal arrives while shutting down,
+// Run cancels every context it handed out, including the ones given to
+// services and hooks that are still stopping, and returns ErrForcedShutdown
+// without waiting for them.
+//
+// ExitCode maps the error returned to a process exit code.
+func (d *Daemon) Run(ctx context.Context) (err error) {
+	defer func() {
+		d.emit(Event{Kind: EventShutdownComplete, Err: err})
+		d.runErr = err
+		close(d.done)
+	}()
+	d.startTime = time.Now()
+	d.setState(StateStarting)
+	defer d.setState(StateStopped)
+
+	// create a root context that all future contexts will derive from, so that this
+	// cancel func will propagate through all requests
+	ctx, cancelFunc := context.WithCancelCause(ctx)
+	defer cancelFunc(nil)
+
+	// seed context with appropriate values
+	ctx = context.WithValue(ctx, versionKey{}, d.version)
+	ctx = d.logContext(ctx)
+
+	// the shutdown calls get their own context, since ctx may already be canceled
+	// if the caller asked us to stop
+	shutdownCtx := context.WithoutCancel(ctx)
+
+	// set up a separate internal server for handling health checks, pprof and
+	// other things you don't want to expose to the world. it's started first so
+	// probes get answers while everything else is coming up
+	// it only gets the timeouts that stop clients from holding connections open,
+	// since it isn't exposed to the world and isn't bound by the route timeout
+	internalTLS, err := d.internalTLSConfig(ctx)
+	if err != nil {
+		return &StartError{Err: fmt.Errorf("loading internal server certificate: %w", err)}
+	}
+	internalHandler, err := d.filterInternalIPs(d.requireClientCert(d.internalMux()))
+	if err != nil {
+		return &StartError{Err: err}
+	}
+	internal := HTTPService(&http.Server{
+		Addr:              d.internalAddr,
+		Handler:           internalHandler,
+		TLSConfig:         internalTLS,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		IdleTimeout:       defaultIdleTimeout,
+	}, d.listenOptions(nil)...)
+	if err := internal.Start(ctx); err != nil {
+		return &StartError{Err: fmt.Errorf("starting internal server: %w", err)}
+	}
+	d.recordAddr("internal", internal)
+	d.emit(Event{Kind: EventServiceStarted, Name: "internal"})
+	d.watch(ctx, "internal server", internal)
+
+	// run the startup hooks before we start the other services, so we never serve
+	// requests with half-initialized resources. /startup fails until they're done,
+	// and the hooks that finished are stopped at the end
+	started, err := d.runStartupHooks(ctx)
+	if err != nil {
+		return &StartError{Err: errors.Join(err, internal.Stop(shutdownCtx))}
+	}
+
+	// listen for OS level signals to stop the program, dispatching any that have
+	// handlers registered instead
+	signalChan, stopSignals := d.notifySignals()
+	defer stopSignals()
+
+	// start the services in dependency order, with the servers last by default so
+	// that they're the first to stop taking traffic on the way down
+	services, err := orderServices(d.servicesToRun())
+	if err == nil {
+		err = d.startServices(ctx, services)
+	}
+	if err != nil {
+		return &StartError{Err: errors.Join(err,
+			d.stopStartupHooks(shutdownCtx, started),
+			internal.Stop(shutdownCtx),
+		)}
+	}
+
+	for _, s := range services {
+		d.watch(ctx, fmt.Sprintf("service %q", s.name), s.svc)
+	}
+
+	// start the supervised goroutines now that the services they may rely on are up,
+	// along with the health checks that run in the background
+	d.Go("health checks", d.health.Run)
+	d.Go("liveness checks", d.liveness.Run)
+	d.supervisor.start(ctx, d.fail, d.Track)
+
+	// everything is up, so start accepting traffic as soon as the startup tasks
+	// have finished
+	d.runStartupTasks()
+
+	// now that everything has been launched, we're going to block here waiting for
+	// an OS signal, for the caller to cancel ctx, or for something we started to fail.
+	// We only capture the signal to report it as the cause, since we aren't going to
+	// shut down any differently based on SIGTERM vs SIGQUIT
+	var runErr, cause error
+	select {
+	case sig := <-signalChan:
+		cause = &SignalError{Signal: sig}
+	case <-ctx.Done():
+		cause = context.Cause(ctx)
+	case <-d.stop:
+		cause = d.stopCause
+	case runErr = <-d.fatal:
+		cause = runErr
+	}
+	d.logger.Info("shutting down", "cause", cause)
+	d.emit(Event{Kind: EventDrainStarted, Err: cause})
+
+	// keep listening for signals while we shut down. if we receive another one, the
+	// graceful path is taking too long for whoever is sending them, so we'll cancel
+	// everything and return immediately. services and hooks can find out why we're
+	// shutting down from the context they're given
+	forceCtx, forceCancel := context.WithCancelCause(context.WithValue(shutdownCtx, causeKey{}, cause))
+	defer forceCancel(nil)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- d.shutdown(forceCtx, func() { cancelFunc(cause) }, services, internal, started)
+	}()
+
+	select {
+	case err := <-done:
+		err = errors.Join(runErr, err)
+		if err == nil {
+			d.logger.Info("exiting cleanly")
+		}
+		return err
+	case sig := <-signalChan:
+		d.logger.Warn("received signal while shutting down, forcing exit", "signal", sig.String())
+		// canceling the shutdown context makes services give up draining and close
+		// their listeners, and everything left in the graceful path return early
+		forceCancel(ErrForcedShutdown)
+		cancelFunc(ErrForcedShutdown)
+		return errors.Join(runErr, ErrForcedShutdown)
+	}
+}
+
+// Shutdown asks Run to shut the daemon down gracefully, as if it had received a
+// signal, and waits for Run to return. If ctx is done first, Shutdown returns
+// ctx's error while the shutdown carries on. Run's own error is returned by
+// Run and Wait.
+func (d *Daemon) Shutdown(ctx context.Context) error {
+	d.requestStop(ErrShutdownRequested)
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// requestStop asks Run to shut down because of cause. Only the first request
+// counts.
+func (d *Daemon) requestStop(cause error) {
+	d.stopOnce.Do(func() {
+		d.stopCause = cause
+		close(d.stop)
+	})
+}
+
+// Wait blocks until Run has returned and returns the same error.
+func (d *Daemon) Wait() error {
+	<-d.done
+	return d.runErr
+}
+
+// shutdown is the graceful path taken once Run has been asked to stop. ctx is
+// canceled if the shutdown is forced, and cancelFunc cancels the root context
+// that requests derive from.
+func (d *Daemon) shutdown(ctx context.Context, cancelFunc func(), services []namedService, internal Service, started []hook) error {
+	// make readiness check start failing so load balancers will stop sending requests here
+	d.lbDeadline.Store(time.Now().Add(d.preShutdownDelay).UnixNano())
+	d.beginShutdown()
+
+	// load balancers take a while to notice, so keep serving whatever they still send
+	// us for a bit before we stop taking new requests
+	if d.preShutdownDelay > 0 {
+		d.logger.Info("waiting for load balancers to stop sending requests", "delay", d.preShutdownDelay)
+		t := time.NewTimer(d.preShutdownDelay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+
+	// first we want to gracefully stop the services in reverse dependency order but leave the internal
+	// server running. we can't guarantee how long the shutdown will take if there are
+	// long-running / misbehaving requests, so we'll give it a deadline after which services
+	// are expected to give up. we're not canceling the root context yet because that will
+	// cause requests respecting it to error out and return, when what we want is for them to
+	// finish successfully if possible
+	d.stopping.Store(true)
+	drainCtx, drainCancel := d.drainContext(ctx)
+	// event streams would hold up the servers until the timeout, so end them
+	// first. the servers don't wait for the WebSockets they handed over, so close
+	// those alongside them
+	d.drainEventStreams()
+	webSocketsClosed := make(chan struct{})
+	go func() {
+		defer close(webSocketsClosed)
+		d.closeWebSockets(drainCtx)
+	}()
+	stopErr := d.stopServices(drainCtx, services)
+	<-webSocketsClosed
+	timedOut := context.Cause(drainCtx) == ErrShutdownTimeout
+	drainCancel()
+	switch {
+	case stopErr != nil && timedOut:
+		stopErr = fmt.Errorf("%w: %w", ErrShutdownTimeout, stopErr)
+		d.logger.Error("shutdown timed out", "err", stopErr)
+	case stopErr != nil:
+		d.logger.Error("shutdown finished with an error", "err", stopErr)
+	default:
+		d.logger.Info("shutdown finished successfully")
+	}
+
+	// regardless whether the services successfully stopped, now we are going to cancel all contexts.
+	// hopefully all your handlers respect these timeouts and will quit executing any long running requests
+	// if they are still running. If everything stopped successfully, it's still good practice to
+	// cancel your contexts when you are done with them
+	d.setState(StateStopping)
+	cancelFunc()
+	// give any remaining requests and background work some time to return. no
+	// more goroutines can be started while we wait, such as the handler of a
+	// signal that arrives now
+	d.supervisor.stop()
+	d.waitInflight(ctx)
+
+	// run the registered cleanup steps, eliminating temp files you may have created, closing connections
+	// to databases or other services you may have instantiated, etc.
+	hookErr := errors.Join(
+		d.runShutdownHooks(ctx),
+		d.stopStartupHooks(ctx, started),
+	)
+
+	// now shutdown the internal health check server. you could also implement a timeout here,
+	// but since presumably you are in control of both the client and server, it may be unnecessary
+	if err := internal.Stop(ctx); err != nil {
+		d.logger.Error("stopping internal server", "err", err)
+	}
+
+	return errors.Join(stopErr, hookErr)
+}
+
+// Health returns the registry of checks that gate the daemon's readiness, so
+// components can register checks for the dependencies they need.
+func (d *Daemon) Health() *health.Registry {
+	return d.health
+}
+
+// Liveness returns the registry of checks that gate the daemon's liveness. It
+// starts out empty, so liveness always passes, and is meant for checks that
+// only fail when restarting the process is the fix, such as a health.Watchdog.
+func (d *Daemon) Liveness() *health.Registry {
+	return d.liveness
+}
+
+// Track registers a unit of background work with the daemon, which waits for
+// it to finish after canceling the root context on shutdown. The returned func
+// must be called when the work is done.
+func (d *Daemon) Track() (done func()) {
+	d.inflight.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(d.inflight.Done)
+	}
+}
+
+// waitInflight waits for in-flight requests and tracked work to return, giving
+// up after the cancel wait or when ctx is done.
+func (d *Daemon) waitInflight(ctx context.Context) {
+	finished := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(finished)
+	}()
+
+	t := time.NewTimer(d.cancelWait)
+	defer t.Stop()
+	select {
+	case <-finished:
+	case <-t.C:
+		d.logger.Warn("timed out waiting for in-flight work to return")
+	case <-ctx.Done():
+	}
+}
+
+// fail makes the daemon shut down because of err. Only the first failure is
+// kept; by the time later ones arrive the daemon is already shutting down.
+func (d *Daemon) fail(err error) {
+	select {
+	case d.fatal <- err:
+	default:
+		d.logger.Error("daemon failure after shutdown began", "err", err)
+	}
+}
+
+// watch makes the daemon shut down if svc reports a failure after starting.
+func (d *Daemon) watch(ctx context.Context, name string, svc Service) {
+	f, ok := svc.(Failer)
+	if !ok {
+		return
+	}
+	go func() {
+		select {
+		case err := <-f.Failed():
+			d.fail(fmt.Errorf("%s failed: %w", name, err))
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// isProbe reports whether path is one of the probes, which are served to
+// clients that the internal server's IP filter and client certificate check
+// would otherwise turn away.
+func isProbe(path string) bool {
+	switch path {
+	case "/liveness", "/readiness", "/startup":
+		return true
+	}
+	return false
+}
+
+// terseProbe returns a copy of the probe request r without its Accept header
+// and ?verbose=, so a client that bypassed the internal server's checks only
+// learns the status code, not which checks fail or why.
+func terseProbe(r *http.Request) *http.Request {
+	r = r.Clone(r.Context())
+	r.Header.Del("Accept")
+	query := r.URL.Query()
+	query.Del("verbose")
+	r.URL.RawQuery = query.Encode()
+	r.RequestURI = r.URL.RequestURI()
+	return r
+}
+
+// internalMux builds the handler for the internal server.
+// DO NOT USE http.DefaultServeMux because you don't know what's registered there
+// e.g. net/http/pprof automatically registers endpoints
+func (d *Daemon) internalMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// liveness passes unless one of its checks, such as a watchdog, says the
+	// process is wedged and should be restarted
+	mux.Handle("/liveness", d.liveness.Handler())
+
+	// readiness fails outright unless we're ready to take traffic, and otherwise
+	// depends on the checks registered for databases and other network services
+	checks := d.health.Handler()
+	// startup passes for good once the startup hooks have run and everything has
+	// started, for orchestrators to hold off on liveness checks until then
+	mux.HandleFunc("/startup", func(w http.ResponseWriter, r *http.Request) {
+		if d.hasStarted() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	mux.HandleFunc("/readiness", func(w http.ResponseWriter, r *http.Request) {
+		if state := d.State(); state != StateReady {
+			switch health.Negotiate(r) {
+			case health.FormatJSON:
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(map[string]string{"status": "fail", "state": state.String()})
+			case health.FormatText:
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, "fail (%s)\n", state)
+			default:
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		checks.ServeHTTP(w, r)
+	})
+
+	// everything in the metrics registry: the daemon's own metrics, the requests
+	// and health checks below, and whatever the application registered
+	mux.Handle("/metrics", d.metrics.Handler())
+
+	// counts and latencies of the health checks, for dashboards to scrape
+	mux.Handle("/readiness/metrics", d.health.MetricsHandler())
+
+	// the rate, errors and duration of requests to the other servers by route
+	mux.Handle("/requests/metrics", d.requestMetrics.Handler())
+
+	// the latest results of each check, to see when and why one flapped
+	mux.Handle("/readiness/history", d.health.HistoryHandler())
+
+	// the signals we've received, to help work out who asked us to stop and when
+	mux.HandleFunc("/signals", d.serveSignalHistory)
+
+	// a page for people to look at, with the state and health of the daemon
+	mux.HandleFunc("/status", d.serveStatus)
+
+	// profiles of the running process, for go tool pprof
+	if d.pprof {
+		mux.HandleFunc("/debug/pprof/", servePprof)
+	}
+
+	// the variables published with expvar, for scraping without a metrics stack
+	if d.expvar {
+		mux.Handle("/debug/vars", expvar.Handler())
+	}
+
+	// goroutine dumps and memory stats, and knobs for the runtime, for debugging
+	// the live process
+	if d.diagnostics {
+		mux.HandleFunc("/debug/goroutines", serveGoroutines)
+		mux.HandleFunc("/debug/memory", serveMemory)
+		mux.HandleFunc("/admin/gc", d.requireAdmin(serveAdminGC))
+		mux.HandleFunc("/admin/runtime", d.requireAdmin(serveAdminRuntime))
+	}
+
+	// lets operators take the instance out of rotation without killing it
+	mux.HandleFunc("/admin/ready", d.requireAdmin(d.serveAdminReady))
+
+	// lets operators turn off a check while its dependency is down for maintenance
+	mux.HandleFunc("/admin/checks", d.requireAdmin(d.serveAdminChecks))
+
+	// lets operators drain or stop the instance without sending it signals
+	mux.HandleFunc("/admin/drain", d.requireAdmin(d.serveAdminDrain))
+	mux.HandleFunc("/admin/shutdown", d.requireAdmin(d.serveAdminShutdown))
+
+	// lets operators raise or lower the limit on requests in flight
+	if d.shedder != nil {
+		mux.HandleFunc("/admin/loadshed", d.requireAdmin(d.serveAdminLoadShed))
+	}
+
+	// lets operators turn up logging while they look into a problem
+	mux.HandleFunc("/admin/loglevel", d.requireAdmin(d.serveAdminLogLevel))
+
+	return mux
+}
@@ -0,0 +1,196 @@
+package daemon
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"time"
+)
+
+// WithDiagnostics serves endpoints for debugging the live process on the
+// internal server:
+//
+//   - GET /debug/goroutines dumps the stack of every goroutine as text.
+//   - GET /debug/memory reports heap and garbage collector stats as JSON.
+//   - POST /admin/gc runs a garbage collection and returns the memory held by
+//     the heap to the OS.
+//   - GET /admin/runtime reports GOMAXPROCS, GOGC and GOMEMLIMIT, and PUT or
+//     POST changes them with ?gomaxprocs=, ?gogc= and ?memlimit=, as in
+//     ?gogc=off or ?gomaxprocs=default.
+//
+// The /admin endpoints change how the process runs, so like the other admin
+// endpoints they require the token set with WithAdminToken. Changes to the
+// runtime last until the process exits.
+func WithDiagnostics() Option {
+	return func(d *Daemon) {
+		d.diagnostics = true
+	}
+}
+
+// serveGoroutines writes the stack of every goroutine, growing the buffer until
+// they all fit.
+func serveGoroutines(w http.ResponseWriter, r *http.Request) {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(buf)
+}
+
+// serveMemory reports the heap and the garbage collector's work so far.
+func serveMemory(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var gc debug.GCStats
+	gc.PauseQuantiles = make([]time.Duration, 5)
+	debug.ReadGCStats(&gc)
+	// LastGC is the Unix epoch until the first collection
+	var last time.Time
+	if gc.NumGC > 0 {
+		last = gc.LastGC
+	}
+
+	type heap struct {
+		Alloc    uint64 `json:"alloc"`
+		Inuse    uint64 `json:"inuse"`
+		Idle     uint64 `json:"idle"`
+		Released uint64 `json:"released"`
+		Objects  uint64 `json:"objects"`
+		Sys      uint64 `json:"sys"`
+	}
+	type gcStats struct {
+		Count       int64     `json:"count"`
+		Last        time.Time `json:"last,omitzero"`
+		NextHeap    uint64    `json:"next_heap"`
+		PauseTotal  string    `json:"pause_total"`
+		PauseMin    string    `json:"pause_min"`
+		PauseMedian string    `json:"pause_median"`
+		PauseMax    string    `json:"pause_max"`
+		CPUFraction float64   `json:"cpu_fraction"`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"heap": heap{
+			Alloc:    m.HeapAlloc,
+			Inuse:    m.HeapInuse,
+			Idle:     m.HeapIdle,
+			Released: m.HeapReleased,
+			Objects:  m.HeapObjects,
+			Sys:      m.HeapSys,
+		},
+		"gc": gcStats{
+			Count:       gc.NumGC,
+			Last:        last,
+			NextHeap:    m.NextGC,
+			PauseTotal:  gc.PauseTotal.String(),
+			PauseMin:    gc.PauseQuantiles[0].String(),
+			PauseMedian: gc.PauseQuantiles[2].String(),
+			PauseMax:    gc.PauseQuantiles[4].String(),
+			CPUFraction: m.GCCPUFraction,
+		},
+		"sys":        m.Sys,
+		"goroutines": runtime.NumGoroutine(),
+	})
+}
+
+// serveAdminGC runs a garbage collection on POST, and reports how long it took
+// and how much it freed.
+func serveAdminGC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	debug.FreeOSMemory()
+	took := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"took":        took.String(),
+		"heap_before": before.HeapAlloc,
+		"heap_after":  after.HeapAlloc,
+	})
+}
+
+// serveAdminRuntime reports the runtime's knobs, and changes the ones given in
+// the query on PUT or POST. Nothing is changed unless every value is valid.
+func serveAdminRuntime(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		query := r.URL.Query()
+		var changes []func()
+		if v := query.Get("gomaxprocs"); v != "" {
+			if v == "default" {
+				changes = append(changes, runtime.SetDefaultGOMAXPROCS)
+			} else {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 {
+					http.Error(w, "gomaxprocs must be a positive number or default", http.StatusBadRequest)
+					return
+				}
+				changes = append(changes, func() { runtime.GOMAXPROCS(n) })
+			}
+		}
+		if v := query.Get("gogc"); v != "" {
+			percent := -1
+			if v != "off" {
+				var err error
+				if percent, err = strconv.Atoi(v); err != nil || percent < 0 {
+					http.Error(w, "gogc must be a percentage or off", http.StatusBadRequest)
+					return
+				}
+			}
+			changes = append(changes, func() { debug.SetGCPercent(percent) })
+		}
+		if v := query.Get("memlimit"); v != "" {
+			limit := int64(math.MaxInt64)
+			if v != "off" {
+				var err error
+				if limit, err = strconv.ParseInt(v, 10, 64); err != nil || limit < 0 {
+					http.Error(w, "memlimit must be a number of bytes or off", http.StatusBadRequest)
+					return
+				}
+			}
+			changes = append(changes, func() { debug.SetMemoryLimit(limit) })
+		}
+		for _, change := range changes {
+			change()
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	samples := []metrics.Sample{{Name: "/gc/gogc:percent"}, {Name: "/gc/gomemlimit:bytes"}}
+	metrics.Read(samples)
+	knobs := map[string]any{
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"gogc":       "off",
+		"memlimit":   "off",
+	}
+	// the runtime reports GOGC=off as -1
+	if gogc := int64(samples[0].Value.Uint64()); gogc >= 0 {
+		knobs["gogc"] = gogc
+	}
+	if limit := samples[1].Value.Uint64(); limit < math.MaxInt64 {
+		knobs["memlimit"] = limit
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(knobs)
+}
@@ -0,0 +1,229 @@
+// Package daemon runs an HTTP service alongside an internal server for health
+// checks and shuts both down gracefully when the process is asked to stop.
+//
+// It is the daemon demo from the September 2018 lightning talks turned into a
+// library, so services no longer have to copy main.go around:
+//
+//	mux := http.NewServeMux()
+//	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+//		w.Write([]byte("hello world"))
+//	})
+//
+//	d := daemon.New(mux)
+//	err := d.Run(context.Background())
+//	if err != nil {
+//		d.Logger().Error("daemon stopped", "err", err)
+//	}
+//	os.Exit(daemon.ExitCode(err))
+//
+// By default the main server listens on APP_PORT and the internal server, which
+// exposes /liveness, /readiness and /startup, listens on INTERNAL_PORT. The
+// internal server starts before the startup hooks run, and /startup only
+// passes once they have finished and everything else has started, so it can
+// back a Kubernetes startupProbe. Both addresses, the shutdown timings and the
+// main server's timeouts, which by default keep slow clients from tying up
+// connections, can be set with options:
+//
+//	d := daemon.New(mux,
+//		daemon.WithAddr(":8080"),
+//		daemon.WithInternalAddr("127.0.0.1:8081"),
+//		daemon.WithShutdownTimeout(30*time.Second),
+//	)
+//
+// A request still running when the route timeout passes has its context
+// canceled, and is answered with a 504 Gateway Timeout if it hasn't started its
+// response. WithRouteTimeouts gives routes that need longer, such as uploads,
+// their own timeout. A caller can also pass the time it has left in an
+// X-Request-Timeout-Ms header, which shortens the request's deadline to match,
+// and the clients made by the httpclient package send it along to the services
+// they call, so a chain of calls shares one time budget.
+//
+// The daemon logs structured entries to a *slog.Logger, JSON on stdout unless
+// WithLogger gives it another, including when health checks start failing and
+// recover. The logger is carried in the root context, so handlers and
+// middleware find it with httpmw.LoggerFromContext and log the same way.
+//
+// A request whose handler panics is answered with a 500 Internal Server Error
+// rather than having its connection dropped, and the panic is logged with its
+// stack and counted in Panics.
+//
+// Every request also gets an ID, taken from its X-Request-ID header or
+// generated, which httpmw.RequestIDFromContext returns and the response echoes
+// back, so the request can be traced through the logs of each service it
+// passes through. WithAccessLog logs each request along with its ID. Requests
+// likewise join the trace named by their traceparent or B3 headers, or start
+// one, and httpmw.TraceTransport passes it on to the services they call. The
+// telemetry package records them as OpenTelemetry spans.
+//
+// The rate, errors and duration of the requests to each server are recorded by
+// route and status class, and served at /requests/metrics on the internal
+// server in the Prometheus text format.
+//
+// /metrics on the internal server serves everything in the daemon's metrics
+// registry in one scrape: its lifecycle state, connections, requests in flight
+// and panics, the request metrics, the counts and latencies of its health
+// checks, and the runtime's goroutines, heap, garbage collection pauses, file
+// descriptors and uptime. Applications add their own collectors, such as a
+// breaker.Set, with Metrics().Register, or single values with
+// Metrics().GaugeFunc. Where nothing scrapes it, telemetry.NewMeterProvider
+// pushes the same metrics over OTLP.
+//
+// Readiness only passes while the daemon is ready to take traffic and every
+// check in its health registry passes, so components register checks for the
+// dependencies they can't work without:
+//
+//	d.Health().Register("db", dbChecker)
+//
+// Checks registered with health.Background run on their own interval while the
+// daemon is running, and readiness reports their latest result. Requesting
+// /readiness?verbose=1, or sending an Accept header for JSON or plain text,
+// also returns each check's status, latency and error. ?include= and ?exclude=
+// pick which checks a probe depends on. /readiness/history serves the latest
+// results of each check, and /readiness/metrics their counts and latencies in
+// the Prometheus text format.
+//
+// Liveness passes unless one of the checks registered with Liveness fails,
+// such as a health.Watchdog waiting on heartbeats from a component that has
+// stopped making progress:
+//
+//	wd := health.NewWatchdog()
+//	d.Liveness().Register("watchdog", wd)
+//	hb := wd.Heartbeat("event loop", 30*time.Second)
+//
+// WithPprof serves runtime profiles under /debug/pprof/ on the internal server,
+// for go tool pprof, and WithExpvar serves expvar's variables at /debug/vars,
+// including the daemon's state, uptime, requests and connections.
+// WithDiagnostics adds goroutine dumps and memory stats, along with admin
+// endpoints to run the garbage collector and change GOMAXPROCS, GOGC and
+// GOMEMLIMIT.
+//
+// For people rather than probes, /status on the internal server shows the
+// daemon's state, uptime, open connections and build info along with the
+// results of its checks.
+//
+// Health().SetReady(false) takes the instance out of rotation without stopping
+// it. Operators can do the same by sending PUT /admin/ready?ready=false to the
+// internal server, once WithAdminToken has set the bearer token the admin
+// endpoints require. PUT /admin/checks?name=db&enabled=false&for=1h likewise
+// turns a single check off, e.g. while its dependency is down for planned
+// maintenance. /admin/drain drains and resumes the daemon, /admin/shutdown
+// shuts it down, and /admin/loglevel changes the level of LogLevel, which the
+// default logger and the application's loggers can follow, as does sending the
+// process SIGUSR1, which toggles debug logging on and off. WithAdminHeader
+// accepts the token in a header of its own, and RequireAdmin protects the
+// application's own endpoints with it.
+//
+// Work that needs the services up but should still finish before the daemon
+// takes traffic, such as warming a cache, can be registered with StartupTask.
+// Readiness keeps failing until every startup task has returned.
+//
+// WithTLS makes the main server serve HTTPS, reloading its certificate on
+// SIGHUP and whenever the files change. Other servers can do the same with a
+// CertReloader as their TLSConfig. WithAutocert instead obtains and renews
+// certificates from Let's Encrypt, answering its challenges on port 80.
+//
+// WithInternalTLS protects the internal server with mutual TLS, so its admin
+// endpoints can be reached from beyond localhost by clients holding a
+// certificate, while the probes stay open. WithInternalIPFilter likewise limits
+// them to trusted address ranges, in case the port is exposed by mistake.
+//
+// HandleHost gives a host name its own handler on the main server, so a daemon
+// can serve several sites, such as api.example.com and admin.example.com, from
+// separate muxes:
+//
+//	d.HandleHost("api.example.com", apiMux)
+//	d.HandleHost("*.admin.example.com", adminMux)
+//
+// Services that need more listeners, such as a partner API, can add more HTTP
+// servers with AddServer. They are run just like the main server.
+//
+// Middleware from the httpmw package, or any func(http.Handler) http.Handler,
+// can wrap every server with WithMiddleware, or a single server added with
+// AddServer with ServerMiddleware, e.g. to limit each client's request rate:
+//
+//	daemon.WithMiddleware(httpmw.RateLimit(10, 20))
+//
+// Browser-facing services can answer cross-origin requests the same way with
+// httpmw.CORS.
+//
+// WithH2C lets the main server take HTTP/2 without TLS, e.g. for gRPC clients
+// behind a proxy that terminates TLS. WithHTTP3 serves the main handler over
+// QUIC as well, advertising it to clients with an Alt-Svc header.
+//
+// WithLoadShedding caps the requests in flight across the servers, turning
+// away the rest with a 503 rather than letting them queue up, and
+// /admin/loadshed changes the cap at runtime.
+//
+// WithDrainRejection answers new requests with a 503 and a Retry-After header
+// once the daemon starts draining, so clients retry on another instance rather
+// than being cut off by the shutdown timeout.
+//
+// WithMaxConns caps the connections the main server keeps open, and MaxConns
+// does the same for the others, with the connections turned away counted in
+// Connections.
+//
+// Behind a load balancer in TCP mode, such as HAProxy or an AWS Network Load
+// Balancer, ProxyProtocol reads the client's address from the PROXY protocol
+// header the load balancer sends, so r.RemoteAddr is the client's rather than
+// the load balancer's.
+//
+// KeepAlive, ReusePort and Backlog tune the servers' sockets for load balancers
+// whose health checks don't get along with the defaults.
+//
+// WithBindRetry makes the servers keep trying to bind an address that is still
+// held by a previous instance for a while, instead of failing to start.
+//
+// A server given a port of 0, such as "127.0.0.1:0", listens on a free port
+// picked by the OS, which Addr reports once it has started.
+//
+// Any of the servers can listen on a Unix socket rather than a TCP port by
+// giving it an address such as "unix:/run/app.sock", with SocketMode setting
+// the socket's permissions. A socket left behind by a previous process is
+// replaced, and the socket is removed when the server stops.
+//
+// Anything else with a lifecycle, such as a queue consumer, can implement
+// Service and be added with AddService so it is started and stopped along with
+// the main server. DependsOn declares what a service needs, and the daemon
+// starts services in dependency order and stops them in reverse:
+//
+//	d.AddService("db", dbPool)
+//	d.AddService("consumer", consumer, daemon.DependsOn("db"))
+//
+// The grpcservice package adapts a gRPC server to a Service, and Listen gives
+// other servers the same listeners as the daemon's own. MuxRPC instead serves
+// gRPC, gRPC-Web and Connect alongside regular routes on the main server's
+// listener.
+//
+// Background goroutines can be run with Supervise, which restarts them
+// according to a RestartPolicy. If a service or supervised goroutine fails for
+// good, the daemon shuts down rather than carrying on without it. Supervised
+// goroutines, requests on the main server and any work registered with Track
+// are waited on after the root context is canceled, up to the cancel wait.
+//
+// Other components can follow the daemon's lifecycle through State and
+// OnStateChange, or receive a structured Event for each step, such as a service
+// starting or a hook finishing, by registering with Subscribe.
+//
+// SIGQUIT, SIGINT and SIGTERM shut the daemon down by default; the set can be
+// changed with WithShutdownSignals, and HandleSignal gives a signal its own
+// handler instead. SIGHUP reloads every Reloader added with AddReloader,
+// rolling back the ones already reloaded if another fails.
+//
+// Upgrade replaces the running binary without downtime outside Kubernetes: it
+// starts a new copy of the process, hands it every server's listening socket,
+// and shuts down once the new process is ready.
+//
+// On Windows, where only os.Interrupt and SIGTERM are delivered, those shut
+// the daemon down, and RunWindowsService runs it under the Service Control
+// Manager when the process is started as a Windows service.
+//
+// While draining, the daemon reports how many connections are still in the
+// middle of a request. WebSockets registered with AddWebSocket are sent a close
+// frame and given until the shutdown timeout to finish closing, and
+// Server-Sent Events streams started with NewEventStream are sent a final
+// reconnect event and ended. WithMaxShutdownTimeout lets the drain run past the
+// shutdown timeout as long as those keep finishing.
+//
+// Once the daemon has been asked to stop, a second signal abandons the graceful
+// shutdown and makes Run return ErrForcedShutdown right away.
+package daemon
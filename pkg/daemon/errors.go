@@ -0,0 +1,73 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+)
+
+// Exit codes returned by ExitCode.
+const (
+	// ExitOK means the daemon shut down cleanly.
+	ExitOK = 0
+	// ExitFailure means the daemon stopped with an error not covered by a more
+	// specific code, such as a failing shutdown hook.
+	ExitFailure = 1
+	// ExitStartFailed means a startup hook or service failed to start.
+	ExitStartFailed = 2
+	// ExitListenFailed means a server could not bind its address.
+	ExitListenFailed = 3
+	// ExitShutdownTimeout means services were still draining when the shutdown
+	// timeout expired.
+	ExitShutdownTimeout = 4
+	// ExitForced means the daemon received another signal while shutting down
+	// and gave up on the graceful path.
+	ExitForced = 5
+)
+
+// ErrShutdownTimeout is returned by Run, wrapped with the errors reported by
+// the services, when they have not stopped within the shutdown timeout.
+var ErrShutdownTimeout = errors.New("shutdown timed out")
+
+// ErrForcedShutdown is returned by Run when it receives another signal while
+// shutting down.
+var ErrForcedShutdown = errors.New("shutdown forced")
+
+// StartError is returned by Run when the daemon could not start. Everything
+// that had already started has been stopped again by the time it is returned.
+type StartError struct {
+	Err error
+}
+
+func (e *StartError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode maps an error returned by Run to a process exit code, so a clean
+// exit, a failure to bind a listener, a shutdown that timed out and one that
+// was forced can be told apart by whatever supervises the process.
+//
+//	os.Exit(daemon.ExitCode(d.Run(ctx)))
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var startErr *StartError
+	if errors.As(err, &startErr) {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "listen" {
+			return ExitListenFailed
+		}
+		return ExitStartFailed
+	}
+	if errors.Is(err, ErrForcedShutdown) {
+		return ExitForced
+	}
+	if errors.Is(err, ErrShutdownTimeout) {
+		return ExitShutdownTimeout
+	}
+	return ExitFailure
+}
@@ -0,0 +1,73 @@
+package daemon
+
+import (
+	"os"
+	"time"
+)
+
+// EventKind identifies what happened in a lifecycle Event.
+type EventKind string
+
+const (
+	// EventStartupHookFinished is emitted when a startup hook's start or stop
+	// func returns. Name is the hook and Err what it returned.
+	EventStartupHookFinished EventKind = "startup_hook_finished"
+	// EventServiceStarted is emitted when a service, including the main and
+	// internal servers, has started. Name is the service.
+	EventServiceStarted EventKind = "service_started"
+	// EventStartupTaskFinished is emitted when a startup task returns. Name is
+	// the task and Err what it returned.
+	EventStartupTaskFinished EventKind = "startup_task_finished"
+	// EventSignalReceived is emitted when the daemon receives an OS signal.
+	EventSignalReceived EventKind = "signal_received"
+	// EventDrainStarted is emitted when the daemon starts shutting down. Err is
+	// the failure that caused it, if any.
+	EventDrainStarted EventKind = "drain_started"
+	// EventServiceStopped is emitted when a service has stopped. Name is the
+	// service and Err what its Stop returned.
+	EventServiceStopped EventKind = "service_stopped"
+	// EventShutdownHookFinished is emitted when a shutdown hook returns. Name is
+	// the hook and Err what it returned.
+	EventShutdownHookFinished EventKind = "shutdown_hook_finished"
+	// EventReloadFinished is emitted when a reload finishes. Err is what Reload
+	// returned.
+	EventReloadFinished EventKind = "reload_finished"
+	// EventShutdownComplete is emitted just before Run returns. Err is the error
+	// Run returns.
+	EventShutdownComplete EventKind = "shutdown_complete"
+)
+
+// Event describes something that happened during the daemon's lifecycle.
+// Fields that don't apply to the Kind are left empty.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	// Name is the service or hook the event is about.
+	Name string
+	// Signal is the signal received.
+	Signal os.Signal
+	// Duration is how long the hook or service took.
+	Duration time.Duration
+	Err      error
+}
+
+// Subscribe registers fn to be called with every lifecycle event, e.g. to ship
+// them to logs or metrics. Events are delivered one at a time, in the order
+// they happen, and fn must not block for long since the daemon waits for it
+// before carrying on.
+func (d *Daemon) Subscribe(fn func(Event)) {
+	d.eventsMu.Lock()
+	defer d.eventsMu.Unlock()
+	d.subscribers = append(d.subscribers, fn)
+}
+
+// emit stamps e with the current time and delivers it to subscribers.
+func (d *Daemon) emit(e Event) {
+	e.Time = time.Now()
+
+	d.eventsMu.Lock()
+	defer d.eventsMu.Unlock()
+	for _, fn := range d.subscribers {
+		fn(e)
+	}
+}
@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/metrics"
+)
+
+// WithExpvar serves the variables published with the expvar package as JSON at
+// /debug/vars on the internal server, for lightweight scraping without a
+// metrics stack. The daemon publishes its own under "daemon": its lifecycle
+// state, version, uptime, requests in flight, panics and connections, and the
+// runtime's goroutines, heap, garbage collections and file descriptors. Only
+// the first daemon created with WithExpvar in a process publishes them.
+//
+// Like any program that imports expvar, the process also serves /debug/vars on
+// http.DefaultServeMux, so a server added with a nil handler exposes them too.
+func WithExpvar() Option {
+	return func(d *Daemon) {
+		d.expvar = true
+	}
+}
+
+// publishExpvars publishes the daemon's variables, unless another daemon
+// already has.
+func (d *Daemon) publishExpvars() {
+	if !d.expvar || expvar.Get("daemon") != nil {
+		return
+	}
+	expvar.Publish("daemon", expvar.Func(d.expvars))
+}
+
+func (d *Daemon) expvars() any {
+	var uptime float64
+	if !d.startTime.IsZero() {
+		uptime = time.Since(d.startTime).Seconds()
+	}
+	conns := d.Connections()
+	rt := metrics.ReadRuntime()
+	return map[string]any{
+		"state":             d.State().String(),
+		"version":           d.version,
+		"uptime_seconds":    uptime,
+		"requests_inflight": d.requests.Load(),
+		"panics":            d.panics.Load(),
+		"connections": map[string]any{
+			"active":     conns.Active,
+			"idle":       conns.Idle,
+			"websockets": conns.WebSockets,
+			"rejected":   conns.Rejected,
+		},
+		"runtime": map[string]any{
+			"goroutines":       rt.Goroutines,
+			"heap_alloc_bytes": rt.HeapAlloc,
+			"heap_objects":     rt.HeapObjects,
+			"sys_bytes":        rt.Sys,
+			"gc_cycles":        rt.GCCycles,
+			"gc_pause_seconds": rt.GCPauseTotal.Seconds(),
+			"open_fds":         rt.OpenFDs,
+			"max_fds":          rt.MaxFDs,
+			"uptime_seconds":   rt.Uptime.Seconds(),
+		},
+	}
+}
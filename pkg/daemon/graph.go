@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ServiceOption configures a service added with AddService.
+type ServiceOption func(*namedService)
+
+// DependsOn declares that a service needs the named services, or the main
+// server under the name "main", to be running. It is started after them and
+// stopped before them.
+func DependsOn(names ...string) ServiceOption {
+	return func(s *namedService) {
+		s.deps = append(s.deps, names...)
+	}
+}
+
+// orderServices sorts services so each one comes after its dependencies.
+// Services with no ordering constraint between them keep the order they were
+// added in. It fails if a dependency is unknown or the dependencies form a
+// cycle.
+func orderServices(services []namedService) ([]namedService, error) {
+	index := make(map[string]int, len(services))
+	for i, s := range services {
+		if _, ok := index[s.name]; ok {
+			return nil, fmt.Errorf("service %q added more than once", s.name)
+		}
+		index[s.name] = i
+	}
+
+	// count the unmet dependencies of each service and remember who is waiting
+	// on whom, so services can be released as their dependencies are placed
+	pending := make([]int, len(services))
+	dependents := make([][]int, len(services))
+	for i, s := range services {
+		for _, dep := range s.deps {
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("service %q depends on unknown service %q", s.name, dep)
+			}
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	ordered := make([]namedService, 0, len(services))
+	placed := make([]bool, len(services))
+	for len(ordered) < len(services) {
+		// always place the earliest added service that is ready, so the order
+		// is stable
+		next := -1
+		for i := range services {
+			if !placed[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			var cycle []string
+			for i, s := range services {
+				if !placed[i] {
+					cycle = append(cycle, fmt.Sprintf("%q", s.name))
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between services %s", strings.Join(cycle, ", "))
+		}
+		placed[next] = true
+		ordered = append(ordered, services[next])
+		for _, i := range dependents[next] {
+			pending[i]--
+		}
+	}
+	return ordered, nil
+}
@@ -0,0 +1,77 @@
+// Package grpcservice runs a gRPC server as a daemon.Service, so it is started
+// and drained along with the daemon's HTTP servers:
+//
+//	srv := grpc.NewServer()
+//	pb.RegisterGreeterServer(srv, greeter)
+//	d.AddService("grpc", grpcservice.New(srv, ":9090"))
+//
+// Registering a grpchealth.Server on srv serves the daemon's readiness over
+// the gRPC Health Checking Protocol as well.
+package grpcservice
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"google.golang.org/grpc"
+
+	"github.com/forgeutah/utah-go/pkg/daemon"
+)
+
+// New returns a Service that serves s on addr. Start binds addr before
+// returning, with opts applying as they do to the daemon's HTTP servers, and
+// Stop calls s.GracefulStop, which stops accepting new RPCs and waits for the
+// ones in flight, falling back to s.Stop to cancel them once ctx is done. The
+// Service is also a daemon.Failer reporting unexpected errors from s.Serve,
+// and a daemon.Addresser.
+func New(s *grpc.Server, addr string, opts ...daemon.ListenOption) daemon.Service {
+	return &service{s: s, addr: addr, opts: opts, failed: make(chan error, 1)}
+}
+
+type service struct {
+	s      *grpc.Server
+	addr   string
+	opts   []daemon.ListenOption
+	ln     net.Listener
+	failed chan error
+}
+
+func (svc *service) Start(ctx context.Context) error {
+	ln, err := daemon.Listen(ctx, svc.addr, svc.opts...)
+	if err != nil {
+		return err
+	}
+	svc.ln = ln
+	go func() {
+		if err := svc.s.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			svc.failed <- err
+		}
+	}()
+	return nil
+}
+
+func (svc *service) Stop(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		svc.s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		// cancel the RPCs still running, which makes GracefulStop return too
+		svc.s.Stop()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+func (svc *service) Addr() net.Addr {
+	return svc.ln.Addr()
+}
+
+func (svc *service) Failed() <-chan error {
+	return svc.failed
+}
@@ -0,0 +1,177 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// hook is a named step run during the daemon's lifecycle. Startup hooks may
+// also carry a stop func that undoes what fn did.
+type hook struct {
+	name    string
+	fn      func(ctx context.Context) error
+	stop    func(ctx context.Context) error
+	timeout time.Duration
+	group   string
+}
+
+// HookOption configures a hook registered with OnStartup or OnShutdown.
+type HookOption func(*hook)
+
+// HookTimeout limits how long each call to the hook may take. The context
+// passed to the hook is canceled once timeout has passed.
+func HookTimeout(timeout time.Duration) HookOption {
+	return func(h *hook) {
+		h.timeout = timeout
+	}
+}
+
+// InGroup puts a shutdown hook in a group of hooks that can safely run at the
+// same time, e.g. closing Redis and Kafka clients. The whole group runs
+// concurrently at the position of its most recently registered hook. It has no
+// effect on startup hooks.
+func InGroup(name string) HookOption {
+	return func(h *hook) {
+		h.group = name
+	}
+}
+
+// OnStartup registers start to run before the daemon starts its services and
+// servers, e.g. to run migrations, open database pools or warm caches. Only the
+// internal server is up while they run, with /startup failing. Startup hooks
+// run in the order they were registered. If one fails, the stop funcs of the
+// hooks that already finished are called in reverse order and Run returns the
+// error without starting anything else. Once the daemon is running, stop funcs are called in reverse order
+// after the shutdown hooks. stop may be nil.
+func (d *Daemon) OnStartup(name string, start, stop func(ctx context.Context) error, opts ...HookOption) {
+	h := hook{name: name, fn: start, stop: stop}
+	for _, opt := range opts {
+		opt(&h)
+	}
+	d.hooksMu.Lock()
+	defer d.hooksMu.Unlock()
+	d.startupHooks = append(d.startupHooks, h)
+}
+
+// OnShutdown registers fn to run once the main server has drained and request
+// contexts have been canceled, e.g. to close database pools or flush buffers.
+// Hooks run in the reverse order they were registered, like deferred calls,
+// except for grouped hooks which run together, and every hook runs even if an
+// earlier one fails.
+func (d *Daemon) OnShutdown(name string, fn func(ctx context.Context) error, opts ...HookOption) {
+	h := hook{name: name, fn: fn}
+	for _, opt := range opts {
+		opt(&h)
+	}
+	d.hooksMu.Lock()
+	defer d.hooksMu.Unlock()
+	d.shutdownHooks = append(d.shutdownHooks, h)
+}
+
+// call runs fn, which is one of h's funcs, within h's timeout.
+func (h hook) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	return fn(ctx)
+}
+
+// runStartupHooks runs the registered startup hooks in order. It returns the
+// hooks that finished so they can be stopped later, or rolls them back and
+// returns an error if any hook fails.
+func (d *Daemon) runStartupHooks(ctx context.Context) ([]hook, error) {
+	d.hooksMu.Lock()
+	hooks := append([]hook(nil), d.startupHooks...)
+	d.hooksMu.Unlock()
+
+	for i, h := range hooks {
+		start := time.Now()
+		err := h.call(ctx, h.fn)
+		d.emit(Event{Kind: EventStartupHookFinished, Name: h.name, Duration: time.Since(start), Err: err})
+		if err != nil {
+			err = fmt.Errorf("startup hook %q: %w", h.name, err)
+			d.logger.Error("startup hook failed", "hook", h.name, "err", err)
+			return nil, errors.Join(err, d.stopStartupHooks(ctx, hooks[:i]))
+		}
+		d.logger.Info("startup hook finished", "hook", h.name, "duration", time.Since(start))
+	}
+	return hooks, nil
+}
+
+// runShutdownHooks runs the registered shutdown hooks in reverse order, running
+// each group concurrently when its most recently registered hook is reached,
+// and returns the errors they reported, each wrapped with the hook's name.
+func (d *Daemon) runShutdownHooks(ctx context.Context) error {
+	d.hooksMu.Lock()
+	hooks := append([]hook(nil), d.shutdownHooks...)
+	d.hooksMu.Unlock()
+
+	errs := make([]error, len(hooks))
+	ran := make(map[string]bool)
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		if h.group == "" {
+			errs[i] = d.runShutdownHook(ctx, h)
+			continue
+		}
+		if ran[h.group] {
+			continue
+		}
+		ran[h.group] = true
+
+		var wg sync.WaitGroup
+		for j := i; j >= 0; j-- {
+			if hooks[j].group != h.group {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[j] = d.runShutdownHook(ctx, hooks[j])
+			}()
+		}
+		wg.Wait()
+	}
+	return errors.Join(errs...)
+}
+
+func (d *Daemon) runShutdownHook(ctx context.Context, h hook) error {
+	start := time.Now()
+	err := h.call(ctx, h.fn)
+	d.emit(Event{Kind: EventShutdownHookFinished, Name: h.name, Duration: time.Since(start), Err: err})
+	if err != nil {
+		err = fmt.Errorf("shutdown hook %q: %w", h.name, err)
+		d.logger.Error("shutdown hook failed", "hook", h.name, "err", err)
+		return err
+	}
+	d.logger.Info("shutdown hook finished", "hook", h.name, "duration", time.Since(start))
+	return nil
+}
+
+// stopStartupHooks calls the stop funcs of hooks in reverse order and returns
+// the errors they reported, each wrapped with the hook's name.
+func (d *Daemon) stopStartupHooks(ctx context.Context, hooks []hook) error {
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		if h.stop == nil {
+			continue
+		}
+		start := time.Now()
+		err := h.call(ctx, h.stop)
+		d.emit(Event{Kind: EventStartupHookFinished, Name: h.name, Duration: time.Since(start), Err: err})
+		if err != nil {
+			err = fmt.Errorf("stopping startup hook %q: %w", h.name, err)
+			d.logger.Error("stopping startup hook failed", "hook", h.name, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		d.logger.Info("startup hook stopped", "hook", h.name, "duration", time.Since(start))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// WithHTTP3 makes the daemon also serve the main handler over HTTP/3, on the
+// UDP port matching the main server's TCP port. Responses from the main server
+// carry an Alt-Svc header advertising it, so browsers and other clients that
+// speak HTTP/3 switch over. HTTP/3 always uses TLS, so the main server must be
+// configured with WithTLS or WithAutocert, and it must listen on a TCP port
+// rather than a Unix socket.
+func WithHTTP3() Option {
+	return func(d *Daemon) {
+		d.http3 = true
+	}
+}
+
+// HTTP3Service adapts s to a Service, like HTTPService does for an
+// http.Server. Start binds the UDP address in s.Addr before returning, with
+// RetryBind being the only ListenOption that applies, and
+// Stop calls s.Shutdown, which sends clients a GOAWAY frame and waits for the
+// requests in flight, closing the connections still active once ctx is done.
+// Unless s already has a ConnContext, request contexts derive from the context
+// passed to Start. The returned Service is also a Failer reporting unexpected
+// errors from s.Serve, and an Addresser.
+func HTTP3Service(s *http3.Server, opts ...ListenOption) Service {
+	return &http3Service{s: s, listen: newListenConfig(opts), failed: make(chan error, 1)}
+}
+
+type http3Service struct {
+	s      *http3.Server
+	listen listenConfig
+	addr   string
+	conn   *net.UDPConn
+	failed chan error
+}
+
+func (h *http3Service) Start(ctx context.Context) error {
+	if h.s.TLSConfig == nil {
+		return errors.New("HTTP/3 requires a TLS config")
+	}
+	addr := h.s.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	conn, err := h.listen.listenPacket(ctx, addr)
+	if err != nil {
+		return err
+	}
+	h.addr = addr
+	h.conn = conn
+	if h.s.ConnContext == nil {
+		h.s.ConnContext = func(connCtx context.Context, _ *quic.Conn) context.Context {
+			return valuesContext{Context: ctx, values: connCtx}
+		}
+	}
+	go func() {
+		err := h.s.Serve(conn)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, quic.ErrServerClosed) {
+			h.failed <- err
+		}
+	}()
+	return nil
+}
+
+func (h *http3Service) Addr() net.Addr {
+	return h.conn.LocalAddr()
+}
+
+func (h *http3Service) Failed() <-chan error {
+	return h.failed
+}
+
+func (h *http3Service) Stop(ctx context.Context) error {
+	// Shutdown closes the connections it can't wait for any longer itself
+	err := h.s.Shutdown(ctx)
+	openListeners.remove("udp:"+h.addr, h.conn)
+	h.conn.Close()
+	return err
+}
+
+// valuesContext is canceled along with Context but looks values up in values
+// first, so a request context can derive from the daemon's root context while
+// keeping what the server stored on the connection.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key any) any {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+	return c.Context.Value(key)
+}
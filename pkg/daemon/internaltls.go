@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// WithInternalTLS makes the internal server serve HTTPS with the certificate
+// in certFile and its key in keyFile, and verify client certificates against
+// the CAs in clientCAFile, so it can safely be reachable beyond localhost.
+// The probes, /liveness, /readiness and /startup, are still served to clients
+// without a certificate, since the kubelet doesn't present one, though they
+// only get the status code, never the verbose or detailed reports with the
+// checks' errors. Every other endpoint requires a certificate signed by one of
+// the CAs. The server
+// certificate is reloaded like the main server's with WithTLS, while the CAs
+// are read once when the daemon starts.
+func WithInternalTLS(certFile, keyFile, clientCAFile string, interval time.Duration) Option {
+	return func(d *Daemon) {
+		if interval <= 0 {
+			interval = defaultCertWatchInterval
+		}
+		d.internalTLS = NewCertReloader(certFile, keyFile)
+		d.internalTLSWatchInterval = interval
+		d.internalClientCAFile = clientCAFile
+	}
+}
+
+// setupInternalTLS registers what the internal server's certificate needs to
+// be kept current.
+func (d *Daemon) setupInternalTLS() {
+	if d.internalTLS == nil {
+		return
+	}
+	d.AddReloader("internal tls certificate", d.internalTLS)
+	d.Go("internal tls certificate watcher", func(ctx context.Context) {
+		d.internalTLS.Watch(ctx, d.internalTLSWatchInterval)
+	})
+}
+
+// internalTLSConfig loads the internal server's certificate and client CAs and
+// returns its TLS config, or nil if WithInternalTLS wasn't used. The internal
+// server starts before the startup hooks run, so this can't be one of them.
+func (d *Daemon) internalTLSConfig(ctx context.Context) (*tls.Config, error) {
+	if d.internalTLS == nil {
+		return nil, nil
+	}
+	if err := d.internalTLS.Reload(ctx); err != nil {
+		return nil, err
+	}
+	pem, err := os.ReadFile(d.internalClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	cas := x509.NewCertPool()
+	if !cas.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", d.internalClientCAFile)
+	}
+	config := d.internalTLS.TLSConfig()
+	config.ClientCAs = cas
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return config, nil
+}
+
+// requireClientCert only lets requests with a verified client certificate
+// through to h, except for the probes, which get a terse answer instead, when
+// the internal server uses mutual TLS.
+func (d *Daemon) requireClientCert(h http.Handler) http.Handler {
+	if d.internalTLS == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			if !isProbe(r.URL.Path) {
+				http.Error(w, "client certificate required", http.StatusForbidden)
+				return
+			}
+			r = terseProbe(r)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+// WithInternalIPFilter only lets clients whose address is in one of the ranges
+// in allow, and none of those in deny, reach the internal server's endpoints,
+// so pprof and the admin endpoints stay out of reach even if its port is
+// exposed by mistake. Ranges are given as CIDRs such as "10.0.0.0/8", or single
+// addresses such as "127.0.0.1", and an empty allow list allows every address
+// that isn't denied. The probes, /liveness, /readiness and /startup, are still
+// served to every client, since the kubelet's address isn't always known in
+// advance, but clients outside the ranges only get the status code, never the
+// verbose or detailed reports with the checks' errors. Run fails if a range
+// can't be parsed.
+func WithInternalIPFilter(allow, deny []string) Option {
+	return func(d *Daemon) {
+		d.internalAllow = allow
+		d.internalDeny = deny
+	}
+}
+
+// filterInternalIPs wraps the internal server's handler h in the filter set
+// with WithInternalIPFilter, if any.
+func (d *Daemon) filterInternalIPs(h http.Handler) (http.Handler, error) {
+	if len(d.internalAllow) == 0 && len(d.internalDeny) == 0 {
+		return h, nil
+	}
+	allow, err := httpmw.ParseCIDRs(d.internalAllow...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing internal allowlist: %w", err)
+	}
+	deny, err := httpmw.ParseCIDRs(d.internalDeny...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing internal denylist: %w", err)
+	}
+	filtered := httpmw.IPFilter(allow, deny)(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !isProbe(r.URL.Path):
+			filtered.ServeHTTP(w, r)
+		case httpmw.IPAllowed(r, allow, deny):
+			h.ServeHTTP(w, r)
+		default:
+			h.ServeHTTP(w, terseProbe(r))
+		}
+	}), nil
+}
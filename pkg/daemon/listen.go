@@ -0,0 +1,385 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+// ListenOption configures how a server listens.
+type ListenOption func(*listenConfig)
+
+type listenConfig struct {
+	socketMode    os.FileMode
+	maxConns      int
+	bindRetry     time.Duration
+	proxyProtocol bool
+	keepAlive     *net.KeepAliveConfig
+	reusePort     bool
+	backlog       int
+	// onReject is called for every connection turned away by maxConns
+	onReject func()
+}
+
+// SocketMode sets the permissions of the socket file when a server listens on
+// a Unix socket, e.g. 0o660 so only the reverse proxy's group can connect. By
+// default the socket gets the process's umask.
+func SocketMode(mode os.FileMode) ListenOption {
+	return func(c *listenConfig) {
+		c.socketMode = mode
+	}
+}
+
+// MaxConns limits how many connections the server has open at once, so a
+// traffic spike can't exhaust the process's file descriptors. Connections
+// beyond the limit are closed as soon as they are accepted, and counted in
+// ConnStats.Rejected for the daemon's servers.
+func MaxConns(n int) ListenOption {
+	return func(c *listenConfig) {
+		c.maxConns = n
+	}
+}
+
+// RetryBind keeps trying to bind the server's address for up to window while
+// it is in use, backing off exponentially between attempts, rather than
+// failing to start right away. This rides out a previous instance that is
+// slow to let go of the port.
+func RetryBind(window time.Duration) ListenOption {
+	return func(c *listenConfig) {
+		c.bindRetry = window
+	}
+}
+
+// KeepAlive configures the TCP keep-alive probes sent on the server's
+// connections. Go sends them after 15 seconds of idleness by default, which
+// doesn't suit every load balancer: some drop idle connections sooner, and
+// some treat the probes as activity. A config with Enable false turns them off.
+func KeepAlive(cfg net.KeepAliveConfig) ListenOption {
+	return func(c *listenConfig) {
+		c.keepAlive = &cfg
+	}
+}
+
+// ReusePort sets SO_REUSEPORT on the server's socket, so several processes can
+// listen on the same port and the kernel spreads connections across them. Go
+// already sets SO_REUSEADDR on Unix, so a restarted server can bind a port that
+// still has connections in TIME_WAIT. ReusePort is supported on Linux, macOS,
+// the BSDs and AIX, which have SO_REUSEPORT.
+func ReusePort() ListenOption {
+	return func(c *listenConfig) {
+		c.reusePort = true
+	}
+}
+
+// Backlog sets how many connections the kernel queues for the server before it
+// accepts them, which Go otherwise takes from the system's limit, such as
+// net.core.somaxconn on Linux. A smaller queue makes a load balancer's health
+// checks fail fast when the server falls behind, rather than wait in line. The
+// kernel still caps the queue at the system's limit. Backlog is only supported
+// on Unix.
+func Backlog(n int) ListenOption {
+	return func(c *listenConfig) {
+		c.backlog = n
+	}
+}
+
+// ListenWith configures how a server added with AddServer listens.
+func ListenWith(opts ...ListenOption) ServiceOption {
+	return func(s *namedService) {
+		s.listenOpts = append(s.listenOpts, opts...)
+	}
+}
+
+// WithListenOptions configures how the main server listens.
+func WithListenOptions(opts ...ListenOption) Option {
+	return func(d *Daemon) {
+		d.listenOpts = append(d.listenOpts, opts...)
+	}
+}
+
+// WithBindRetry makes every server the daemon runs retry binding its address
+// for up to window while it is in use, as RetryBind does for a single server.
+// Servers can still set their own window with RetryBind.
+func WithBindRetry(window time.Duration) Option {
+	return func(d *Daemon) {
+		d.bindRetry = window
+	}
+}
+
+// WithMaxConns limits how many connections the main server has open at once.
+// It is short for WithListenOptions(MaxConns(n)).
+func WithMaxConns(n int) Option {
+	return WithListenOptions(MaxConns(n))
+}
+
+// Listen listens on addr the way the daemon's HTTP servers do, for services
+// that serve other protocols, such as a gRPC server. addr may name a Unix
+// socket as in "unix:/run/app.sock", the listener is handed over by Upgrade,
+// and opts apply as they do to a server. ctx only limits how long RetryBind
+// keeps trying.
+func Listen(ctx context.Context, addr string, opts ...ListenOption) (net.Listener, error) {
+	return newListenConfig(opts).listen(ctx, addr)
+}
+
+// listenOptions puts the daemon's defaults for every server before opts, so
+// opts can override them.
+func (d *Daemon) listenOptions(opts []ListenOption) []ListenOption {
+	return append([]ListenOption{RetryBind(d.bindRetry)}, opts...)
+}
+
+func newListenConfig(opts []ListenOption) listenConfig {
+	var c listenConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// listen listens on addr, which is a TCP address, or the path of a Unix socket
+// prefixed with "unix:", such as "unix:/run/app.sock". If the process was
+// started by Upgrade, the listener for addr handed over by the old process is
+// used instead. Open listeners are tracked so they can be handed over in turn.
+func (c listenConfig) listen(ctx context.Context, addr string) (net.Listener, error) {
+	ln, ok := inheritedListener(ctx, addr).(net.Listener)
+	if !ok {
+		var err error
+		ln, err = retryBind(ctx, c.bindRetry, addr, func() (net.Listener, error) {
+			return c.open(ctx, addr)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	openListeners.add(addr, ln)
+	ln = &trackedListener{Listener: ln, addr: addr}
+	if c.proxyProtocol {
+		ln = &proxyListener{Listener: ln}
+	}
+	if c.maxConns > 0 {
+		ln = &limitListener{Listener: ln, sem: make(chan struct{}, c.maxConns), onReject: c.onReject}
+	}
+	return ln, nil
+}
+
+// listenPacket listens for UDP packets on addr, using the socket handed over
+// by the old process if the process was started by Upgrade. The socket is
+// tracked under "udp:" followed by addr until the caller removes it from
+// openListeners.
+func (c listenConfig) listenPacket(ctx context.Context, addr string) (*net.UDPConn, error) {
+	if conn, ok := inheritedListener(ctx, "udp:"+addr).(*net.UDPConn); ok {
+		openListeners.add("udp:"+addr, conn)
+		return conn, nil
+	}
+	conn, err := retryBind(ctx, c.bindRetry, addr, func() (net.PacketConn, error) {
+		return c.netListenConfig().ListenPacket(ctx, "udp", addr)
+	})
+	if err != nil {
+		return nil, err
+	}
+	openListeners.add("udp:"+addr, conn)
+	return conn.(*net.UDPConn), nil
+}
+
+// open opens a new listener for addr. A socket file left behind by a process
+// that didn't get to clean up is removed first, and the socket is removed again
+// when the listener is closed.
+func (c listenConfig) open(ctx context.Context, addr string) (net.Listener, error) {
+	lc := c.netListenConfig()
+	path, unix := strings.CutPrefix(addr, "unix:")
+	var ln net.Listener
+	var err error
+	if unix {
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		ln, err = lc.Listen(ctx, "unix", path)
+	} else {
+		ln, err = lc.Listen(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if unix && c.socketMode != 0 {
+		if err := os.Chmod(path, c.socketMode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("setting permissions of %s: %w", path, err)
+		}
+	}
+	if c.backlog > 0 {
+		if err := controlSocket(ln.(syscall.Conn), func(fd uintptr) error {
+			return setBacklog(fd, c.backlog)
+		}); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("setting backlog of %s: %w", addr, err)
+		}
+	}
+	return ln, nil
+}
+
+// netListenConfig returns the net.ListenConfig that sets up sockets as the
+// options say.
+func (c listenConfig) netListenConfig() *net.ListenConfig {
+	var lc net.ListenConfig
+	if c.keepAlive != nil {
+		lc.KeepAliveConfig = *c.keepAlive
+		if !c.keepAlive.Enable {
+			lc.KeepAlive = -1
+		}
+	}
+	if c.reusePort {
+		lc.Control = func(network, address string, rc syscall.RawConn) error {
+			return controlRaw(rc, setReusePort)
+		}
+	}
+	return &lc
+}
+
+// controlSocket calls fn with the file descriptor of conn's socket.
+func controlSocket(conn syscall.Conn, fn func(fd uintptr) error) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return controlRaw(rc, fn)
+}
+
+func controlRaw(rc syscall.RawConn, fn func(fd uintptr) error) error {
+	var err error
+	if cerr := rc.Control(func(fd uintptr) { err = fn(fd) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+const (
+	minBindBackoff = 100 * time.Millisecond
+	maxBindBackoff = 2 * time.Second
+)
+
+// retryBind calls bind until it succeeds, fails for a reason other than addr
+// being in use, window has passed or ctx is done.
+func retryBind[T any](ctx context.Context, window time.Duration, addr string, bind func() (T, error)) (T, error) {
+	deadline := time.Now().Add(window)
+	backoff := minBindBackoff
+	for {
+		v, err := bind()
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) || time.Now().Add(backoff).After(deadline) {
+			return v, err
+		}
+		httpmw.LoggerFromContext(ctx).Warn("address in use, retrying", "addr", addr, "backoff", backoff)
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return v, err
+		}
+		backoff = min(backoff*2, maxBindBackoff)
+	}
+}
+
+// removeStaleSocket removes the socket file at path unless something is still
+// listening on it, in which case listening fails as the address is in use.
+func removeStaleSocket(path string) error {
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and isn't a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// openListeners are the listeners and UDP sockets open in this process by
+// address.
+var openListeners listenerSet
+
+type listenerSet struct {
+	mu sync.Mutex
+	m  map[string]io.Closer
+}
+
+func (s *listenerSet) add(addr string, ln io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string]io.Closer)
+	}
+	s.m[addr] = ln
+}
+
+func (s *listenerSet) remove(addr string, ln io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m[addr] == ln {
+		delete(s.m, addr)
+	}
+}
+
+// trackedListener removes itself from openListeners when it is closed.
+type trackedListener struct {
+	net.Listener
+	addr string
+}
+
+func (l *trackedListener) Close() error {
+	openListeners.remove(l.addr, l.Listener)
+	return l.Listener.Close()
+}
+
+// limitListener closes the connections it accepts beyond the capacity of sem.
+type limitListener struct {
+	net.Listener
+	sem      chan struct{}
+	onReject func()
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case l.sem <- struct{}{}:
+			return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+		default:
+			c.Close()
+			if l.onReject != nil {
+				l.onReject()
+			}
+		}
+	}
+}
+
+// limitConn frees its slot in the limitListener once it is closed.
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,96 @@
+package daemon
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/forgeutah/utah-go/pkg/health"
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+// WithLogger sets the logger the daemon logs to. It defaults to JSON on
+// stdout at the level LogLevel returns. The logger is seeded into the root
+// context, so httpmw.LoggerFromContext returns it in handlers and middleware
+// such as WithAccessLog log to it too.
+func WithLogger(logger *slog.Logger) Option {
+	return func(d *Daemon) {
+		d.logger = logger
+	}
+}
+
+// Logger returns the logger the daemon logs to.
+func (d *Daemon) Logger() *slog.Logger {
+	return d.logger
+}
+
+// LogLevel returns the level the application's loggers should log at, which
+// operators can change at runtime with PUT /admin/loglevel?level=debug on the
+// internal server, or by sending the process SIGUSR1, which toggles debug
+// logging on and off. It starts at slog.LevelInfo. The default logger follows
+// it; hand it to the handler of a logger set with WithLogger so that one
+// follows the changes too:
+//
+//	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: d.LogLevel()}))
+func (d *Daemon) LogLevel() *slog.LevelVar {
+	return &d.logLevel
+}
+
+// setLogLevel changes the log level to level, logging the change and what
+// made it.
+func (d *Daemon) setLogLevel(level slog.Level, by string) {
+	d.levelMu.Lock()
+	defer d.levelMu.Unlock()
+	d.setLogLevelLocked(level, by)
+}
+
+func (d *Daemon) setLogLevelLocked(level slog.Level, by string) {
+	from := d.logLevel.Level()
+	if level == from {
+		return
+	}
+	// logged while the more verbose of the two levels is in effect, so turning
+	// debug logging off shows up as well as turning it on
+	if level < from {
+		d.logLevel.Set(level)
+	}
+	d.logger.Info("log level changed", "from", from.String(), "to", level.String(), "by", by)
+	d.logLevel.Set(level)
+}
+
+// toggleDebug switches the log level to debug, or back to the level it was at
+// before if it already is debug. SIGUSR1 does this by default, so debug logging
+// can be turned on during an incident without reaching the internal server.
+func (d *Daemon) toggleDebug(ctx context.Context) {
+	d.levelMu.Lock()
+	defer d.levelMu.Unlock()
+	if d.logLevel.Level() <= slog.LevelDebug {
+		d.setLogLevelLocked(d.levelBeforeDebug, "signal")
+		return
+	}
+	d.levelBeforeDebug = d.logLevel.Level()
+	d.setLogLevelLocked(slog.LevelDebug, "signal")
+}
+
+// defaultLogger returns the logger used if WithLogger wasn't.
+func (d *Daemon) defaultLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: &d.logLevel}))
+}
+
+// logHealthChanges logs the checks in reg, the readiness or liveness checks,
+// as they start failing and recover.
+func (d *Daemon) logHealthChanges(reg *health.Registry, kind string) {
+	reg.OnCheckChange(func(res health.Result) {
+		if res.Err != nil {
+			d.logger.Warn("health check failing", "kind", kind, "check", res.Name, "err", res.Err)
+		} else {
+			d.logger.Info("health check recovered", "kind", kind, "check", res.Name)
+		}
+	})
+}
+
+// logContext returns a context carrying the daemon's logger, for the parts of
+// the daemon that find their logger with httpmw.LoggerFromContext.
+func (d *Daemon) logContext(ctx context.Context) context.Context {
+	return httpmw.ContextWithLogger(ctx, d.logger)
+}
@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/forgeutah/utah-go/pkg/metrics"
+)
+
+// WithMetrics sets the registry served at /metrics on the internal server, e.g.
+// to share one with other parts of the application. By default the daemon
+// creates its own, available from Metrics. Either way the daemon registers its
+// own metrics with it.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(d *Daemon) {
+		d.metrics = reg
+	}
+}
+
+// Metrics returns the registry served at /metrics on the internal server, for
+// the application to register its own collectors with.
+func (d *Daemon) Metrics() *metrics.Registry {
+	return d.metrics
+}
+
+// registerMetrics registers the daemon's lifecycle, server, health and runtime
+// metrics.
+func (d *Daemon) registerMetrics() {
+	reg := d.metrics
+
+	// lifecycle
+	reg.Register(metrics.CollectorFunc(d.writeStateMetrics))
+	reg.GaugeFunc("daemon_start_time_seconds", "When the daemon started running, in seconds since the Unix epoch.", func() float64 {
+		if d.startTime.IsZero() {
+			return 0
+		}
+		return float64(d.startTime.UnixNano()) / 1e9
+	})
+	var reloads, reloadFailures atomic.Int64
+	d.Subscribe(func(e Event) {
+		if e.Kind != EventReloadFinished {
+			return
+		}
+		if e.Err != nil {
+			reloadFailures.Add(1)
+		} else {
+			reloads.Add(1)
+		}
+	})
+	reg.Register(metrics.CollectorFunc(func(w io.Writer) {
+		metrics.WriteHeader(w, "daemon_reloads_total", "counter", "Reloads by result.")
+		fmt.Fprintf(w, "daemon_reloads_total{result=\"success\"} %d\n", reloads.Load())
+		fmt.Fprintf(w, "daemon_reloads_total{result=\"failure\"} %d\n", reloadFailures.Load())
+	}))
+
+	// servers
+	reg.Register(metrics.CollectorFunc(d.writeConnMetrics))
+	reg.GaugeFunc("daemon_requests_in_flight", "Requests being handled by the servers other than the internal one.", func() float64 {
+		return float64(d.requests.Load())
+	})
+	reg.CounterFunc("daemon_panics_total", "Requests whose handlers panicked.", func() float64 {
+		return float64(d.panics.Load())
+	})
+	if d.shedder != nil {
+		reg.GaugeFunc("daemon_concurrency_limit", "How many requests can be in flight at once before load is shed.", func() float64 {
+			return float64(d.shedder.Limit())
+		})
+		reg.CounterFunc("daemon_requests_shed_total", "Requests turned away because too many were in flight.", func() float64 {
+			return float64(d.shedder.Shed())
+		})
+	}
+	reg.Register(d.requestMetrics)
+
+	// health
+	reg.Register(d.health)
+
+	// the runtime and process
+	reg.Register(metrics.RuntimeCollector())
+}
+
+// writeStateMetrics writes the daemon's lifecycle state as a daemon_state gauge
+// that is 1 for the current state and 0 for the others, and its version as
+// daemon_build_info.
+func (d *Daemon) writeStateMetrics(w io.Writer) {
+	current := d.State()
+	metrics.WriteHeader(w, "daemon_state", "gauge", "The daemon's lifecycle state, 1 for the current one.")
+	for s := StateNew; s <= StateStopped; s++ {
+		v := 0
+		if s == current {
+			v = 1
+		}
+		fmt.Fprintf(w, "daemon_state{state=%s} %d\n", metrics.QuoteLabel(s.String()), v)
+	}
+	metrics.WriteHeader(w, "daemon_build_info", "gauge", "Always 1, labeled by the application version.")
+	fmt.Fprintf(w, "daemon_build_info{version=%s} 1\n", metrics.QuoteLabel(d.version))
+}
+
+// writeConnMetrics writes the connections open to the servers other than the
+// internal one.
+func (d *Daemon) writeConnMetrics(w io.Writer) {
+	stats := d.Connections()
+	metrics.WriteHeader(w, "daemon_connections", "gauge", "Open connections by whether they are in the middle of a request.")
+	fmt.Fprintf(w, "daemon_connections{state=\"active\"} %d\n", stats.Active)
+	fmt.Fprintf(w, "daemon_connections{state=\"idle\"} %d\n", stats.Idle)
+	metrics.WriteHeader(w, "daemon_websockets", "gauge", "Connections registered with AddWebSocket.")
+	fmt.Fprintf(w, "daemon_websockets %d\n", stats.WebSockets)
+	metrics.WriteHeader(w, "daemon_connections_rejected_total", "counter", "Connections turned away because a server had as many as MaxConns allows.")
+	fmt.Fprintf(w, "daemon_connections_rejected_total %d\n", stats.Rejected)
+}
@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+// WithMiddleware wraps the handlers of every server the daemon runs, other than
+// the internal one, in mw, with the first wrapping the rest as in httpmw.Chain.
+// Requests pass through it once they are tracked and carry the route timeout,
+// and before any middleware given to a server with ServerMiddleware.
+func WithMiddleware(mw ...httpmw.Middleware) Option {
+	return func(d *Daemon) {
+		d.middleware = append(d.middleware, mw...)
+	}
+}
+
+// WithAccessLog logs every request to the daemon's servers, other than the
+// internal one, with httpmw.AccessLog, once it has been served. opts can leave
+// out noisy paths with httpmw.SkipPaths.
+func WithAccessLog(opts ...httpmw.AccessLogOption) Option {
+	return func(d *Daemon) {
+		d.accessLog = httpmw.AccessLog(nil, opts...)
+	}
+}
+
+// WithLoadShedding limits how many requests the daemon's servers, other than
+// the internal one, have in flight at once, across all of them, with an
+// httpmw.ConcurrencyLimiter. Requests beyond the limit are answered with a 503
+// right away, to protect the latency of the others during a traffic spike.
+// Server-Sent Events streams count towards the limit for as long as they stay
+// open, so the limit has to leave room for them. Operators can change the
+// limit at runtime with PUT /admin/loadshed?limit=200 on the internal server.
+func WithLoadShedding(limit int) Option {
+	return func(d *Daemon) {
+		d.shedder = httpmw.NewConcurrencyLimiter(limit)
+	}
+}
+
+// WithDrainRejection answers new requests to the daemon's servers, other than
+// the internal one, with a 503 Service Unavailable while the daemon is
+// draining, whether because Drain was called or because it is shutting down.
+// The response closes the connection, so clients that retry are sent to
+// another instance by the load balancer rather than having their requests cut
+// off by the shutdown timeout. Its Retry-After header asks them to wait until
+// the pre-shutdown delay set with WithPreShutdownDelay has given the load
+// balancer time to stop sending requests here, but no longer than retryAfter,
+// which is also what is asked while drained with Drain. Requests
+// already in flight when the drain begins carry on, and the probes on the
+// internal server are unaffected.
+//
+// Without it, requests the load balancer sends during the pre-shutdown delay
+// are served as usual, which suits clients that don't retry.
+func WithDrainRejection(retryAfter time.Duration) Option {
+	return func(d *Daemon) {
+		d.drainRetryAfter = max(retryAfter, time.Second)
+	}
+}
+
+// ServerMiddleware wraps the handler of a server added with AddServer in mw,
+// inside the middleware given to WithMiddleware.
+func ServerMiddleware(mw ...httpmw.Middleware) ServiceOption {
+	return func(s *namedService) {
+		s.middleware = append(s.middleware, mw...)
+	}
+}
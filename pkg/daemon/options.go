@@ -0,0 +1,114 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/health"
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+// Option configures a Daemon.
+type Option func(*Daemon)
+
+// WithAddr sets the address the main server listens on. It defaults to
+// ":$APP_PORT". An address such as "unix:/run/app.sock" listens on a Unix
+// socket instead, e.g. behind a reverse proxy or sidecar on the same host.
+func WithAddr(addr string) Option {
+	return func(d *Daemon) {
+		d.addr = addr
+	}
+}
+
+// WithInternalAddr sets the address the internal server listens on. It
+// defaults to ":$INTERNAL_PORT".
+func WithInternalAddr(addr string) Option {
+	return func(d *Daemon) {
+		d.internalAddr = addr
+	}
+}
+
+// WithRouteTimeout sets the deadline applied to every request on the main
+// server. It defaults to 5 seconds. A request that hasn't started its response
+// by then is answered with a 504 Gateway Timeout, as with httpmw.Timeout.
+func WithRouteTimeout(timeout time.Duration) Option {
+	return func(d *Daemon) {
+		d.routeTimeout = timeout
+	}
+}
+
+// WithRouteTimeouts overrides the route timeout for the requests matching each
+// of routes, e.g. to give uploads longer. The read and write timeouts default
+// to the longest of them plus 5 seconds, rather than the route timeout plus 5
+// seconds, so they don't cut those requests short.
+func WithRouteTimeouts(routes ...httpmw.RouteTimeout) Option {
+	return func(d *Daemon) {
+		d.routeTimeouts = append(d.routeTimeouts, routes...)
+	}
+}
+
+// WithShutdownTimeout sets how long the main server is given to finish
+// in-flight requests before contexts are canceled. It defaults to 10 seconds.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(d *Daemon) {
+		d.shutdownTimeout = timeout
+	}
+}
+
+// WithPreShutdownDelay sets how long the daemon keeps serving after readiness
+// starts failing and before it stops any services. In Kubernetes the shutdown
+// signal often arrives before the pod has been removed from the service
+// endpoints, so keeping the main server up a little longer avoids errors for
+// requests that are still being routed here. It defaults to zero.
+func WithPreShutdownDelay(delay time.Duration) Option {
+	return func(d *Daemon) {
+		d.preShutdownDelay = delay
+	}
+}
+
+// WithCancelWait sets the longest the daemon waits after canceling contexts for
+// in-flight requests and tracked background work to return. It defaults to 3
+// seconds.
+func WithCancelWait(wait time.Duration) Option {
+	return func(d *Daemon) {
+		d.cancelWait = wait
+	}
+}
+
+// WithVersion sets the application version seeded into the root context. It
+// defaults to $APP_VERSION.
+func WithVersion(version string) Option {
+	return func(d *Daemon) {
+		d.version = version
+	}
+}
+
+// WithConnContext sets a func that derives the context for each new connection
+// to the main server from the root context, e.g. to store details about the
+// connection that handlers can read from their request context.
+func WithConnContext(fn func(ctx context.Context, c net.Conn) context.Context) Option {
+	return func(d *Daemon) {
+		d.connContext = fn
+	}
+}
+
+// WithH2C makes the main server accept HTTP/2 without TLS alongside HTTP/1.1,
+// for gRPC and other HTTP/2 clients reaching it through a proxy that
+// terminates TLS. Clients must use HTTP/2 with prior knowledge, as the Upgrade
+// header isn't supported. While draining, HTTP/2 connections are sent a GOAWAY
+// frame so clients move new streams elsewhere while the ones in flight finish.
+func WithH2C() Option {
+	return func(d *Daemon) {
+		d.h2c = true
+	}
+}
+
+// WithHealth sets the registry of checks that gate the daemon's readiness, e.g.
+// to share one with other parts of the application. By default the daemon
+// creates its own, available from Health.
+func WithHealth(reg *health.Registry) Option {
+	return func(d *Daemon) {
+		d.health = reg
+	}
+}
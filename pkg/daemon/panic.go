@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+// PanicError is the error a goroutine run by the daemon is treated as having
+// returned when it panics.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the goroutine at the time it panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// GoOption configures a goroutine started with Go.
+type GoOption func(*supervised)
+
+// ShutdownOnPanic makes the daemon shut down gracefully if the goroutine
+// panics, instead of just logging the panic.
+func ShutdownOnPanic() GoOption {
+	return func(g *supervised) {
+		g.fatal = true
+	}
+}
+
+// Go runs fn in a background goroutine tracked by the daemon, so shutdown
+// waits for it after canceling the root context that fn is given. A panic in
+// fn is recovered and logged with its stack rather than crashing the process
+// without draining. Goroutines started before Run begin once all services have
+// started.
+func (d *Daemon) Go(name string, fn func(ctx context.Context), opts ...GoOption) {
+	g := supervised{
+		name:   name,
+		policy: RestartNever,
+		fn: func(ctx context.Context) error {
+			fn(ctx)
+			return nil
+		},
+	}
+	for _, opt := range opts {
+		opt(&g)
+	}
+	d.supervisor.add(g)
+}
+
+// call runs g.fn with ctx, turning a panic into a *PanicError.
+func (g supervised) call(ctx context.Context) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			p := &PanicError{Value: v, Stack: debug.Stack()}
+			httpmw.LoggerFromContext(ctx).Error("goroutine panicked", "goroutine", g.name, "panic", fmt.Sprint(p.Value), "stack", string(p.Stack))
+			err = p
+		}
+	}()
+	return g.fn(ctx)
+}
@@ -0,0 +1,113 @@
+package daemon
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithPprof serves the runtime profiles under /debug/pprof/ on the internal
+// server, in the same format as net/http/pprof, so go tool pprof can fetch
+// them:
+//
+//	go tool pprof http://localhost:8081/debug/pprof/heap
+//
+// net/http/pprof itself isn't used, since importing it registers the same
+// endpoints on http.DefaultServeMux, which a server might be serving to the
+// world.
+func WithPprof() Option {
+	return func(d *Daemon) {
+		d.pprof = true
+	}
+}
+
+var pprofIndex = template.Must(template.New("pprof").Parse(`<!DOCTYPE html>
+<html>
+<head><title>/debug/pprof/</title></head>
+<body>
+<h1>/debug/pprof/</h1>
+<ul>
+{{range .}}<li><a href="{{.Name}}?debug=1">{{.Name}}</a> ({{.Count}})</li>
+{{end}}<li><a href="profile">profile</a>: CPU profile, for ?seconds= (default 30)</li>
+<li><a href="trace">trace</a>: execution trace, for ?seconds= (default 1)</li>
+<li><a href="cmdline">cmdline</a></li>
+</ul>
+</body>
+</html>
+`))
+
+// servePprof serves the index of profiles at /debug/pprof/ and each profile
+// below it.
+func servePprof(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	switch name {
+	case "":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		pprofIndex.Execute(w, pprof.Profiles())
+	case "profile":
+		serveTimedProfile(w, r, 30*time.Second, pprof.StartCPUProfile, pprof.StopCPUProfile)
+	case "trace":
+		serveTimedProfile(w, r, time.Second, trace.Start, trace.Stop)
+	case "cmdline":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, strings.Join(os.Args, "\x00"))
+	default:
+		p := pprof.Lookup(name)
+		if p == nil {
+			http.Error(w, "unknown profile", http.StatusNotFound)
+			return
+		}
+		debug, _ := strconv.Atoi(r.FormValue("debug"))
+		if name == "heap" && r.FormValue("gc") != "" {
+			runtime.GC()
+		}
+		setProfileHeaders(w, name, debug)
+		p.WriteTo(w, debug)
+	}
+}
+
+// serveTimedProfile records a profile with start and stop for the number of
+// seconds requested, or def, and writes it to w.
+func serveTimedProfile(w http.ResponseWriter, r *http.Request, def time.Duration, start func(w io.Writer) error, stop func()) {
+	duration := def
+	if v := r.FormValue("seconds"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil || seconds <= 0 {
+			http.Error(w, "seconds must be a positive number", http.StatusBadRequest)
+			return
+		}
+		duration = time.Duration(seconds * float64(time.Second))
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	setProfileHeaders(w, name, 0)
+	if err := start(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, fmt.Sprintf("could not start %s: %v", name, err), http.StatusInternalServerError)
+		return
+	}
+	t := time.NewTimer(duration)
+	select {
+	case <-t.C:
+	case <-r.Context().Done():
+		t.Stop()
+	}
+	stop()
+}
+
+func setProfileHeaders(w http.ResponseWriter, name string, debug int) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+}
@@ -0,0 +1,209 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyProtocol makes the server expect every connection to start with a PROXY
+// protocol header, version 1 or 2, as sent by HAProxy or an AWS Network Load
+// Balancer in TCP mode. The connection then reports the client's address from
+// the header as its remote address, and the address the client connected to as
+// its local address, so they show up in r.RemoteAddr and under
+// http.LocalAddrContextKey in the request's context. Connections the load
+// balancer makes for its own health checks keep their real addresses.
+//
+// Connections that don't send a valid header within 5 seconds are closed. Only
+// use ProxyProtocol when nothing but the load balancer can reach the server, as
+// anyone else could claim to be connecting from any address.
+func ProxyProtocol() ListenOption {
+	return func(c *listenConfig) {
+		c.proxyProtocol = true
+	}
+}
+
+// ErrBadProxyHeader is returned when reading from a connection that didn't
+// start with a valid PROXY protocol header.
+var ErrBadProxyHeader = errors.New("bad PROXY protocol header")
+
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts every version 2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener reads a PROXY protocol header from the connections it accepts.
+type proxyListener struct {
+	net.Listener
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c}, nil
+}
+
+// proxyConn reads its header on the first call to Read, RemoteAddr or
+// LocalAddr, so a slow client holds up its own goroutine rather than the
+// accept loop.
+type proxyConn struct {
+	net.Conn
+	once sync.Once
+	// r reads what follows the header
+	r             io.Reader
+	err           error
+	remote, local net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	// a version 1 header is at most 107 bytes long, and both versions are at
+	// least 15
+	br := bufio.NewReaderSize(c.Conn, 256)
+	start, err := br.Peek(12)
+	switch {
+	case err != nil:
+		c.err = fmt.Errorf("%w: %w", ErrBadProxyHeader, err)
+	case bytes.Equal(start, proxyV2Signature):
+		c.err = c.readV2(br)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		c.err = c.readV1(br)
+	default:
+		c.err = fmt.Errorf("%w: connection didn't start with one", ErrBadProxyHeader)
+	}
+	if c.err != nil {
+		return
+	}
+
+	// hand over anything the client sent after the header that was read along
+	// with it
+	c.r = c.Conn
+	if n := br.Buffered(); n > 0 {
+		c.r = io.MultiReader(io.LimitReader(br, int64(n)), c.Conn)
+	}
+}
+
+// readV1 reads a header such as "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func (c *proxyConn) readV1(br *bufio.Reader) error {
+	line, err := br.ReadSlice('\n')
+	if err != nil || len(line) > 107 || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return fmt.Errorf("%w: version 1 header isn't a line of at most 107 bytes", ErrBadProxyHeader)
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return fmt.Errorf("%w: malformed version 1 header %q", ErrBadProxyHeader, line)
+	}
+	src, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	if src.Addr().Is4() != (fields[1] == "TCP4") || dst.Addr().Is4() != src.Addr().Is4() {
+		return fmt.Errorf("%w: addresses don't match %s", ErrBadProxyHeader, fields[1])
+	}
+	c.remote = net.TCPAddrFromAddrPort(src)
+	c.local = net.TCPAddrFromAddrPort(dst)
+	return nil
+}
+
+func parseProxyAddr(ip, port string) (netip.AddrPort, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("%w: %w", ErrBadProxyHeader, err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("%w: bad port %q", ErrBadProxyHeader, port)
+	}
+	return netip.AddrPortFrom(addr, uint16(p)), nil
+}
+
+// readV2 reads a binary header: the signature, the version and command, the
+// address family and transport, the length of the rest of the header, and then
+// the addresses followed by extensions, which are skipped.
+func (c *proxyConn) readV2(br *bufio.Reader) error {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(br, fixed); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadProxyHeader, err)
+	}
+	if fixed[12]>>4 != 2 {
+		return fmt.Errorf("%w: unsupported version %d", ErrBadProxyHeader, fixed[12]>>4)
+	}
+	rest := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(br, rest); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadProxyHeader, err)
+	}
+
+	switch fixed[12] & 0xf {
+	case 0:
+		// LOCAL: the load balancer connecting on its own behalf
+		return nil
+	case 1:
+		// PROXY
+	default:
+		return fmt.Errorf("%w: unsupported command %d", ErrBadProxyHeader, fixed[12]&0xf)
+	}
+
+	var size int
+	switch fixed[13] >> 4 {
+	case 1:
+		size = 4
+	case 2:
+		size = 16
+	default:
+		// unspecified or Unix addresses, which are no use as a remote address
+		return nil
+	}
+	if len(rest) < 2*size+4 {
+		return fmt.Errorf("%w: addresses are truncated", ErrBadProxyHeader)
+	}
+	src, _ := netip.AddrFromSlice(rest[:size])
+	dst, _ := netip.AddrFromSlice(rest[size : 2*size])
+	ports := rest[2*size:]
+	c.remote = net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, binary.BigEndian.Uint16(ports)))
+	c.local = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, binary.BigEndian.Uint16(ports[2:])))
+	return nil
+}
@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Reloader is a component that can pick up new configuration, certificates,
+// log levels and the like without restarting the daemon.
+type Reloader interface {
+	// Reload applies the new configuration. If it fails, it must leave the
+	// component as it was.
+	Reload(ctx context.Context) error
+	// Rollback undoes a successful Reload. It is called when a Reloader later in
+	// the same reload fails, so the components never end up with a mix of old
+	// and new configuration.
+	Rollback(ctx context.Context) error
+}
+
+// AddReloader registers r to be reloaded whenever the daemon reloads, which it
+// does on SIGHUP unless another handler is registered for it, or when Reload is
+// called.
+func (d *Daemon) AddReloader(name string, r Reloader) {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+	d.reloaders = append(d.reloaders, namedReloader{name: name, r: r})
+}
+
+type namedReloader struct {
+	name string
+	r    Reloader
+}
+
+// Reload reloads every registered Reloader in the order they were added. If
+// one fails, the ones already reloaded are rolled back in reverse order and
+// the error is returned along with any from rolling back. Only one reload runs
+// at a time.
+func (d *Daemon) Reload(ctx context.Context) error {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	start := time.Now()
+	err := reload(ctx, d.reloaders)
+	d.emit(Event{Kind: EventReloadFinished, Duration: time.Since(start), Err: err})
+	if err != nil {
+		d.logger.Error("reload failed", "err", err)
+		return err
+	}
+	d.logger.Info("reload finished successfully")
+	return nil
+}
+
+func reload(ctx context.Context, reloaders []namedReloader) error {
+	for i, r := range reloaders {
+		if err := r.r.Reload(ctx); err != nil {
+			err = fmt.Errorf("reloading %q: %w", r.name, err)
+			return errors.Join(err, rollback(ctx, reloaders[:i]))
+		}
+	}
+	return nil
+}
+
+func rollback(ctx context.Context, reloaders []namedReloader) error {
+	var errs []error
+	for i := len(reloaders) - 1; i >= 0; i-- {
+		r := reloaders[i]
+		if err := r.r.Rollback(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("rolling back %q: %w", r.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,12 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package daemon
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func setReusePort(fd uintptr) error {
+	return fmt.Errorf("SO_REUSEPORT isn't supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,9 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package daemon
+
+import "golang.org/x/sys/unix"
+
+func setReusePort(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+}
@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// rpcUnavailableMessage is the error message RPCs are rejected with once the
+// daemon has started stopping its servers.
+const rpcUnavailableMessage = "server is shutting down"
+
+// MuxRPC returns a handler that serves gRPC, gRPC-Web and Connect requests
+// with rpc and everything else with h, so an RPC API and regular HTTP routes,
+// such as a grpc-gateway or a web UI, can share the main server's listener.
+// rpc may be a *grpc.Server, which implements http.Handler, or the handlers
+// generated by connect-go mounted on a mux. Native gRPC clients need HTTP/2, so
+// the main server must use TLS or WithH2C.
+//
+// Draining is protocol-aware: once the daemon starts stopping its servers,
+// RPCs that still arrive on open connections are turned away with an
+// unavailable error in their own protocol, which RPC clients know to retry
+// elsewhere, while the ones in flight finish as usual and plain HTTP requests
+// are still served.
+func (d *Daemon) MuxRPC(rpc, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocol := rpcProtocol(r)
+		switch {
+		case protocol == "":
+			h.ServeHTTP(w, r)
+		case d.stopping.Load():
+			writeUnavailable(w, r, protocol)
+		default:
+			rpc.ServeHTTP(w, r)
+		}
+	})
+}
+
+const (
+	protocolGRPC          = "grpc"
+	protocolConnect       = "connect"
+	protocolConnectStream = "connect stream"
+)
+
+// rpcProtocol returns the RPC protocol r uses, or "" for plain HTTP.
+func rpcProtocol(r *http.Request) string {
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	// this covers gRPC-Web too, which reports errors the same way
+	case strings.HasPrefix(contentType, "application/grpc"):
+		return protocolGRPC
+	case strings.HasPrefix(contentType, "application/connect+"):
+		return protocolConnectStream
+	case r.Header.Get("Connect-Protocol-Version") != "", r.URL.Query().Get("connect") == "v1":
+		return protocolConnect
+	}
+	return ""
+}
+
+// writeUnavailable rejects an RPC with an unavailable error in its protocol.
+func writeUnavailable(w http.ResponseWriter, r *http.Request, protocol string) {
+	switch protocol {
+	case protocolGRPC:
+		// a trailers-only response carries the status in the headers
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.Header().Set("Grpc-Status", "14")
+		w.Header().Set("Grpc-Message", rpcUnavailableMessage)
+		w.WriteHeader(http.StatusOK)
+	case protocolConnectStream:
+		// streams end with a message flagged as the end of the stream, which
+		// carries the error
+		end, _ := json.Marshal(map[string]any{
+			"error": map[string]string{"code": "unavailable", "message": rpcUnavailableMessage},
+		})
+		frame := make([]byte, 5, 5+len(end))
+		frame[0] = 2
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(end)))
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusOK)
+		w.Write(append(frame, end...))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"code": "unavailable", "message": rpcUnavailableMessage})
+	}
+}
@@ -0,0 +1,169 @@
+package daemon
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// AddServer registers s to be run by the daemon alongside the main server,
+// e.g. for a partner API on its own port. Requests get the same treatment as
+// on the main server: their contexts derive from the root context, carry the
+// route timeout and are waited on during shutdown. s.Handler is replaced with
+// a handler that does this, so it must be set before calling AddServer. The
+// read, write and idle timeouts s leaves at zero are set to the main server's,
+// so a negative value is needed to turn one off.
+//
+// Servers start after the services added with AddService, once everything
+// they might use is up, and stop before them, with the main server first
+// among them. DependsOn can reorder them like any other service.
+func (d *Daemon) AddServer(name string, s *http.Server, opts ...ServiceOption) {
+	ns := namedService{name: name, server: true}
+	for _, opt := range opts {
+		opt(&ns)
+	}
+	s.Handler = d.serverHandler(name, s.Handler, ns.middleware)
+	d.applyTimeouts(s)
+	d.conns.track(s)
+	ns.svc = HTTPService(s, append(d.listenOptions(ns.listenOpts), d.conns.listenOption)...)
+	d.addService(ns, nil)
+}
+
+// serverHandler wraps the handler of the named server in the daemon's
+// middleware followed by mw, and so requests are tracked as in-flight work,
+// carry the route timeout until they start an event stream, or their caller's
+// deadline if that's sooner, have a request ID and are part of a trace, are
+// answered with a 500 if they panic, are counted in the request metrics and
+// are logged if WithAccessLog is set. While draining, WithDrainRejection turns
+// new requests away.
+func (d *Daemon) serverHandler(name string, h http.Handler, mw []httpmw.Middleware) http.Handler {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	h = httpmw.CaptureRoute(h)
+	h = httpmw.Chain(append(slices.Clone(d.middleware), mw...)...)(h)
+	h = httpmw.Recover(func(*http.Request, any, []byte) {
+		d.panics.Add(1)
+	})(h)
+	// the caller's deadline goes outside the route timeout, so a request that
+	// runs out of the caller's time is answered with a 504 too
+	serve := httpmw.Deadline(httpmw.Timeout(d.routeTimeout, d.routeTimeouts...)(h))
+	// every request counts towards the load shedding limit, streams included,
+	// so no request can get around it
+	if d.shedder != nil {
+		serve = d.shedder.Middleware(serve)
+	}
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.drainRetryAfter > 0 && d.draining.Load() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.drainRetryAfterNow().Seconds()))))
+			w.Header().Set("Connection", "close")
+			http.Error(w, "server is draining", http.StatusServiceUnavailable)
+			return
+		}
+		d.inflight.Add(1)
+		defer d.inflight.Done()
+		d.requests.Add(1)
+		defer d.requests.Add(-1)
+		serve.ServeHTTP(w, r)
+	})
+	// the metrics, access log, trace and request ID go on the outside so 504s
+	// are counted, logged and carry the ID too
+	handler = d.requestMetrics.Middleware(name)(handler)
+	if d.accessLog != nil {
+		handler = d.accessLog(handler)
+	}
+	return httpmw.RequestID(httpmw.Trace(handler))
+}
+
+// drainRetryAfterNow returns how long clients turned away while draining should
+// wait before retrying. While shutting down, that's until the load balancers
+// have had the pre-shutdown delay to stop sending requests here, so the retry
+// goes elsewhere, but no longer than WithDrainRejection's retryAfter.
+func (d *Daemon) drainRetryAfterNow() time.Duration {
+	wait := d.drainRetryAfter
+	if deadline := d.lbDeadline.Load(); deadline != 0 {
+		wait = min(wait, time.Until(time.Unix(0, deadline)))
+	}
+	return max(wait, time.Second)
+}
+
+// servicesToRun lists everything Run has to start, in the order it is started
+// unless dependencies say otherwise: plain services, then the main server,
+// then the other servers.
+func (d *Daemon) servicesToRun() []namedService {
+	d.servicesMu.Lock()
+	defer d.servicesMu.Unlock()
+
+	var services, servers []namedService
+	for _, s := range d.services {
+		if s.server {
+			servers = append(servers, s)
+		} else {
+			services = append(services, s)
+		}
+	}
+	if d.handler != nil || len(d.hosts) > 0 {
+		handler := d.mainHandler()
+		main := &http.Server{
+			Addr:        d.addr,
+			Handler:     d.serverHandler("main", handler, nil),
+			ConnContext: d.connContext,
+		}
+		d.applyTimeouts(main)
+		d.conns.track(main)
+		switch {
+		case d.autocert != nil:
+			main.TLSConfig = d.autocert.TLSConfig()
+		case d.tls != nil:
+			main.TLSConfig = d.tls.TLSConfig()
+		}
+		if d.h2c {
+			main.Protocols = new(http.Protocols)
+			main.Protocols.SetHTTP1(true)
+			main.Protocols.SetHTTP2(true)
+			main.Protocols.SetUnencryptedHTTP2(true)
+		}
+		if d.http3 {
+			h3 := &http3.Server{
+				Addr:    d.addr,
+				Handler: d.serverHandler("http3", handler, nil),
+			}
+			if main.TLSConfig != nil {
+				h3.TLSConfig = http3.ConfigureTLSConfig(main.TLSConfig)
+			}
+			// advertise HTTP/3 on every response over TCP. until the UDP socket is
+			// bound there's no port to advertise, and nothing is added
+			tcpHandler := main.Handler
+			main.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				h3.SetQUICHeaders(w.Header())
+				tcpHandler.ServeHTTP(w, r)
+			})
+			servers = append([]namedService{{name: "http3", svc: HTTP3Service(h3, d.listenOptions(nil)...), server: true}}, servers...)
+		}
+		// HTTPService makes the root context the base context of every request, so
+		// request contexts inherit its values and canceling it propagates through all
+		// requests
+		services = append(services, namedService{name: "main", svc: HTTPService(main, append(d.listenOptions(d.listenOpts), d.conns.listenOption)...), server: true})
+	}
+	return append(services, servers...)
+}
+
+// Panics returns how many requests to the daemon's servers have panicked. Each
+// panic is logged with its stack, and the request answered with a 500 Internal
+// Server Error, or cut off if its response had already started.
+func (d *Daemon) Panics() int64 {
+	return d.panics.Load()
+}
+
+// RequestMetrics returns the rate, errors and duration metrics of the requests
+// to the daemon's servers, other than the internal one, by server, route and
+// status class. The internal server serves them at /requests/metrics in the
+// Prometheus text format.
+func (d *Daemon) RequestMetrics() *httpmw.RequestMetrics {
+	return d.requestMetrics
+}
@@ -0,0 +1,205 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+// Service is a component whose lifecycle is managed by the daemon, such as an
+// HTTP or gRPC server or a queue consumer.
+type Service interface {
+	// Start starts the service and returns once it is ready to do work. Any
+	// long-running work must happen in goroutines started by Start.
+	Start(ctx context.Context) error
+	// Stop gracefully stops the service, giving up when ctx is done.
+	Stop(ctx context.Context) error
+}
+
+// AddService registers svc to be started after the startup hooks and before
+// the daemon reports ready, and stopped alongside the main server when the
+// daemon shuts down. Services start in the order they were added, followed by
+// the servers, unless DependsOn says otherwise, and stop in reverse order.
+func (d *Daemon) AddService(name string, svc Service, opts ...ServiceOption) {
+	d.addService(namedService{name: name, svc: svc}, opts)
+}
+
+func (d *Daemon) addService(s namedService, opts []ServiceOption) {
+	for _, opt := range opts {
+		opt(&s)
+	}
+	d.servicesMu.Lock()
+	defer d.servicesMu.Unlock()
+	d.services = append(d.services, s)
+}
+
+// namedService is a service run by the daemon. server is set for the HTTP
+// servers, which are ordered after the other services, and listenOpts and
+// middleware configure how a server added with AddServer listens and handles
+// requests.
+type namedService struct {
+	name       string
+	svc        Service
+	deps       []string
+	server     bool
+	listenOpts []ListenOption
+	middleware []httpmw.Middleware
+}
+
+// startServices starts services in order. If one fails to start, the services
+// already started are stopped in reverse order and the error is returned.
+func (d *Daemon) startServices(ctx context.Context, services []namedService) error {
+	for i, s := range services {
+		start := time.Now()
+		if err := s.svc.Start(ctx); err != nil {
+			err = fmt.Errorf("starting service %q: %w", s.name, err)
+			d.logger.Error("starting service failed", "service", s.name, "err", err)
+			return errors.Join(err, d.stopServices(ctx, services[:i]))
+		}
+		d.recordAddr(s.name, s.svc)
+		d.emit(Event{Kind: EventServiceStarted, Name: s.name, Duration: time.Since(start)})
+	}
+	return nil
+}
+
+// stopServices stops services in reverse order and returns the errors they
+// reported, each wrapped with the service's name.
+func (d *Daemon) stopServices(ctx context.Context, services []namedService) error {
+	var errs []error
+	for i := len(services) - 1; i >= 0; i-- {
+		s := services[i]
+		start := time.Now()
+		err := s.svc.Stop(ctx)
+		d.emit(Event{Kind: EventServiceStopped, Name: s.name, Duration: time.Since(start), Err: err})
+		if err != nil {
+			err = fmt.Errorf("stopping service %q: %w", s.name, err)
+			d.logger.Error("stopping service failed", "service", s.name, "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Addresser is implemented by services that listen on an address, such as the
+// ones returned by HTTPService, so the daemon can report where they ended up
+// listening.
+type Addresser interface {
+	// Addr returns the address the service is listening on. It is only called
+	// after Start has succeeded.
+	Addr() net.Addr
+}
+
+// Addr returns the address the named service is listening on once it has
+// started, or nil if there's no such service or it doesn't implement
+// Addresser. The main server is called "main" and the internal server
+// "internal". This is how to find the port picked for an address like
+// "127.0.0.1:0", e.g. in integration tests or to register the real port with
+// service discovery.
+func (d *Daemon) Addr(name string) net.Addr {
+	d.servicesMu.Lock()
+	defer d.servicesMu.Unlock()
+	return d.addrs[name]
+}
+
+func (d *Daemon) recordAddr(name string, svc Service) {
+	a, ok := svc.(Addresser)
+	if !ok {
+		return
+	}
+	d.servicesMu.Lock()
+	defer d.servicesMu.Unlock()
+	if d.addrs == nil {
+		d.addrs = make(map[string]net.Addr)
+	}
+	d.addrs[name] = a.Addr()
+}
+
+// Failer is implemented by services that can fail after Start has returned,
+// such as a server whose accept loop dies. The daemon shuts down when an error
+// is received from Failed, rather than carrying on without the service.
+type Failer interface {
+	Failed() <-chan error
+}
+
+// HTTPService adapts s to a Service. Start binds s.Addr before returning, so a
+// port that is already in use is reported as a startup error, and Stop calls
+// s.Shutdown, closing any connections still active once ctx is done. Unless s
+// already has a BaseContext, the context passed to Start becomes the base of
+// every request context, so requests see the daemon's root context values and
+// are canceled along with it. If s has a TLSConfig, it serves HTTPS with the
+// certificates from there. s.Addr may also name a Unix socket, as in
+// "unix:/run/app.sock", which opts can configure further. The returned Service
+// is also a Failer reporting unexpected errors from s.Serve, and an Addresser.
+func HTTPService(s *http.Server, opts ...ListenOption) Service {
+	return &httpService{s: s, listen: newListenConfig(opts), failed: make(chan error, 1)}
+}
+
+type httpService struct {
+	s      *http.Server
+	listen listenConfig
+	ln     net.Listener
+	failed chan error
+}
+
+func (h *httpService) Start(ctx context.Context) error {
+	addr := h.s.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := h.listen.listen(ctx, addr)
+	if err != nil {
+		return err
+	}
+	h.ln = ln
+	if h.s.BaseContext == nil {
+		h.s.BaseContext = func(net.Listener) context.Context {
+			return ctx
+		}
+	}
+	// start serving requests in a goroutine
+	go func() {
+		// Serve blocks until it errors or until s.Shutdown is called. a server with a
+		// TLS config gets its certificates from there
+		var err error
+		if h.s.TLSConfig != nil {
+			err = h.s.ServeTLS(ln, "", "")
+		} else {
+			err = h.s.Serve(ln)
+		}
+		switch err {
+		// don't do anything on a nil error
+		case nil:
+		// this error is immediately returned when s.Shutdown is called, so there's no reason
+		// to report the error
+		case http.ErrServerClosed:
+		default:
+			h.failed <- err
+		}
+	}()
+	return nil
+}
+
+func (h *httpService) Addr() net.Addr {
+	return h.ln.Addr()
+}
+
+func (h *httpService) Failed() <-chan error {
+	return h.failed
+}
+
+func (h *httpService) Stop(ctx context.Context) error {
+	err := h.s.Shutdown(ctx)
+	if err != nil && ctx.Err() != nil {
+		// we ran out of time draining, so cut off whatever is left
+		h.s.Close()
+	}
+	// Shutdown only closes listeners that Serve has started tracking, which may not
+	// have happened yet if we're stopping right after starting
+	h.ln.Close()
+	return err
+}
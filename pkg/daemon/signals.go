@@ -0,0 +1,148 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+const (
+	defaultSignalDebounce = 250 * time.Millisecond
+
+	// signalHistorySize is how many signals SignalHistory remembers.
+	signalHistorySize = 64
+)
+
+// What the daemon did about a signal, as recorded in a SignalRecord.
+const (
+	SignalActionShutdown  = "shutdown"
+	SignalActionHandled   = "handled"
+	SignalActionDebounced = "debounced"
+)
+
+// SignalRecord is an entry in the daemon's signal history.
+type SignalRecord struct {
+	Signal string    `json:"signal"`
+	Time   time.Time `json:"time"`
+	// Action is SignalActionShutdown for a shutdown signal, which forces the
+	// shutdown if it was already underway, SignalActionHandled for a signal
+	// passed to its handler, or SignalActionDebounced for a signal that was
+	// ignored because it repeated too soon.
+	Action string `json:"action"`
+}
+
+// WithSignalDebounce sets how soon a signal has to repeat to be ignored, so a
+// supervisor that sends the same signal twice in quick succession doesn't
+// force a shutdown that just started or run a handler twice. It defaults to
+// 250ms; zero turns debouncing off.
+func WithSignalDebounce(window time.Duration) Option {
+	return func(d *Daemon) {
+		d.signalDebounce = window
+	}
+}
+
+// WithShutdownSignals sets the signals that make the daemon shut down
+// gracefully, or force the shutdown if it is already underway. It defaults to
+// SIGQUIT, SIGINT and SIGTERM, or os.Interrupt and SIGTERM on Windows.
+func WithShutdownSignals(sigs ...os.Signal) Option {
+	return func(d *Daemon) {
+		d.shutdownSignals = sigs
+	}
+}
+
+// HandleSignal registers fn to run in a goroutine started with Go every time
+// the process receives sig, e.g. to dump goroutines on SIGUSR2. A handled
+// signal no longer shuts the daemon down, even if it is one of the shutdown
+// signals. SIGHUP is handled by Reload and SIGUSR1 toggles debug logging
+// unless they are given other handlers, except on Windows which has neither.
+// Handlers must be registered before Run is called.
+func (d *Daemon) HandleSignal(sig os.Signal, fn func(ctx context.Context)) {
+	d.signalsMu.Lock()
+	defer d.signalsMu.Unlock()
+	if d.signalHandlers == nil {
+		d.signalHandlers = make(map[os.Signal]func(ctx context.Context))
+	}
+	d.signalHandlers[sig] = fn
+}
+
+// notifySignals starts listening for the shutdown signals and the ones with
+// handlers. Handled signals are dispatched to their handlers, while shutdown
+// signals are delivered on the returned channel. Calling stop stops listening.
+func (d *Daemon) notifySignals() (shutdown <-chan os.Signal, stop func()) {
+	d.signalsMu.Lock()
+	handlers := make(map[os.Signal]func(ctx context.Context), len(d.signalHandlers))
+	sigs := append([]os.Signal(nil), d.shutdownSignals...)
+	for sig, fn := range d.signalHandlers {
+		handlers[sig] = fn
+		sigs = append(sigs, sig)
+	}
+	d.signalsMu.Unlock()
+
+	signalChan := make(chan os.Signal, len(sigs))
+	signal.Notify(signalChan, sigs...)
+
+	shutdownChan := make(chan os.Signal, 1)
+	quit := make(chan struct{})
+	go func() {
+		last := make(map[os.Signal]time.Time)
+		for {
+			select {
+			case sig := <-signalChan:
+				now := time.Now()
+				prev, seen := last[sig]
+				last[sig] = now
+				if seen && now.Sub(prev) < d.signalDebounce {
+					d.recordSignal(sig, now, SignalActionDebounced)
+					continue
+				}
+
+				d.emit(Event{Kind: EventSignalReceived, Signal: sig})
+				if fn, ok := handlers[sig]; ok {
+					d.recordSignal(sig, now, SignalActionHandled)
+					d.Go("signal "+sig.String(), fn)
+					continue
+				}
+				d.recordSignal(sig, now, SignalActionShutdown)
+				// if a shutdown signal is already waiting to be picked up, there's
+				// no need to queue another
+				select {
+				case shutdownChan <- sig:
+				default:
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	return shutdownChan, func() {
+		signal.Stop(signalChan)
+		close(quit)
+	}
+}
+
+// SignalHistory returns the most recent signals the daemon received, oldest
+// first, including the ones it ignored.
+func (d *Daemon) SignalHistory() []SignalRecord {
+	d.signalsMu.Lock()
+	defer d.signalsMu.Unlock()
+	return append([]SignalRecord(nil), d.signalHistory...)
+}
+
+func (d *Daemon) recordSignal(sig os.Signal, at time.Time, action string) {
+	d.signalsMu.Lock()
+	defer d.signalsMu.Unlock()
+	if len(d.signalHistory) == signalHistorySize {
+		d.signalHistory = append(d.signalHistory[:0], d.signalHistory[1:]...)
+	}
+	d.signalHistory = append(d.signalHistory, SignalRecord{Signal: sig.String(), Time: at, Action: action})
+}
+
+// serveSignalHistory writes the signal history as JSON.
+func (d *Daemon) serveSignalHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.SignalHistory())
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// defaultShutdownSignals are the signals that make the daemon shut down unless
+// WithShutdownSignals says otherwise.
+var defaultShutdownSignals = []os.Signal{syscall.SIGQUIT, syscall.SIGINT, syscall.SIGTERM}
+
+// reloadSignal is the signal that triggers Reload by default.
+var reloadSignal os.Signal = syscall.SIGHUP
+
+// debugSignal is the signal that toggles debug logging by default.
+var debugSignal os.Signal = syscall.SIGUSR1
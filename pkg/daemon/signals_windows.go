@@ -0,0 +1,22 @@
+//go:build windows
+
+package daemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// defaultShutdownSignals are the signals that make the daemon shut down unless
+// WithShutdownSignals says otherwise. Windows only delivers os.Interrupt for
+// Ctrl-C and Ctrl-Break, and SIGTERM when the console is closed or the user
+// logs off.
+var defaultShutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// reloadSignal is the signal that triggers Reload by default. Windows has no
+// equivalent of SIGHUP, so reloads have to be triggered by calling Reload.
+var reloadSignal os.Signal
+
+// debugSignal is the signal that toggles debug logging by default. Windows has
+// no SIGUSR1, so the level can only be changed through /admin/loglevel.
+var debugSignal os.Signal
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package daemon
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func setBacklog(fd uintptr, n int) error {
+	return fmt.Errorf("setting the backlog isn't supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package daemon
+
+import "golang.org/x/sys/unix"
+
+// setBacklog calls listen again on a socket that is already listening, which
+// changes the length of its queue.
+func setBacklog(fd uintptr, n int) error {
+	return unix.Listen(int(fd), n)
+}
@@ -0,0 +1,164 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+// ErrStreamClosed is returned by EventStream.Send once the stream has been
+// closed or the daemon has started shutting down.
+var ErrStreamClosed = errors.New("event stream closed")
+
+// ReconnectEvent is the event an EventStream sends before the daemon closes
+// it on shutdown, telling clients to reconnect, which EventSource does on its
+// own once the stream ends.
+const ReconnectEvent = "reconnect"
+
+// EventStream is a Server-Sent Events response registered with the daemon, so
+// it ends cleanly when the daemon shuts down rather than holding up the drain
+// until the shutdown timeout.
+type EventStream struct {
+	d        *Daemon
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	mu       sync.Mutex
+	closed   bool
+	draining chan struct{}
+}
+
+// NewEventStream starts a Server-Sent Events response on w and registers it
+// with the daemon. The handler sends events with Send until the client goes
+// away or Draining is closed, and must call Close before returning:
+//
+//	stream, err := d.NewEventStream(w, r)
+//	if err != nil {
+//		http.Error(w, err.Error(), http.StatusInternalServerError)
+//		return
+//	}
+//	defer stream.Close()
+//	for {
+//		select {
+//		case msg := <-messages:
+//			stream.Send("message", msg)
+//		case <-stream.Draining():
+//			return
+//		case <-r.Context().Done():
+//			return
+//		}
+//	}
+//
+// When the daemon starts draining, each stream is sent a ReconnectEvent and
+// Draining is closed. Since streams are meant to stay open, starting one lifts
+// the request's route timeout and the server's write timeout, though not a
+// deadline the caller passed in. A request that has already run out of time
+// can't start one.
+func (d *Daemon) NewEventStream(w http.ResponseWriter, r *http.Request) (*EventStream, error) {
+	httpmw.LiftTimeout(r.Context())
+	if err := r.Context().Err(); err != nil {
+		return nil, fmt.Errorf("starting event stream: %w", err)
+	}
+	s := &EventStream{d: d, w: w, rc: http.NewResponseController(w), draining: make(chan struct{})}
+	if err := s.rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return nil, err
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := s.rc.Flush(); err != nil {
+		return nil, fmt.Errorf("starting event stream: %w", err)
+	}
+
+	d.eventStreamsMu.Lock()
+	if d.eventStreams == nil {
+		d.eventStreams = make(map[*EventStream]struct{})
+	}
+	d.eventStreams[s] = struct{}{}
+	draining := d.drainingEventStreams
+	d.eventStreamsMu.Unlock()
+	if draining {
+		s.drain()
+	}
+	return s, nil
+}
+
+// Send sends an event with the given name and data, which may span several
+// lines. An empty name sends an unnamed event, which EventSource delivers as
+// a message event.
+func (s *EventStream) Send(event, data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	return s.send(event, data)
+}
+
+func (s *EventStream) send(event, data string) error {
+	var b strings.Builder
+	if event != "" {
+		fmt.Fprintf(&b, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	if _, err := s.w.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// Draining is closed once the daemon has started shutting down and the
+// handler should return, ending the stream.
+func (s *EventStream) Draining() <-chan struct{} {
+	return s.draining
+}
+
+// Close unregisters the stream from the daemon. Nothing can be sent once it
+// has been called.
+func (s *EventStream) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	s.d.eventStreamsMu.Lock()
+	defer s.d.eventStreamsMu.Unlock()
+	delete(s.d.eventStreams, s)
+}
+
+// drain sends the client a ReconnectEvent and tells the handler to return.
+func (s *EventStream) drain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.send(ReconnectEvent, "")
+	close(s.draining)
+}
+
+// drainEventStreams drains every open event stream, along with any opened from
+// now on.
+func (d *Daemon) drainEventStreams() {
+	d.eventStreamsMu.Lock()
+	d.drainingEventStreams = true
+	streams := make([]*EventStream, 0, len(d.eventStreams))
+	for s := range d.eventStreams {
+		streams = append(streams, s)
+	}
+	d.eventStreamsMu.Unlock()
+
+	if len(streams) > 0 {
+		d.logger.Info("closing event streams", "count", len(streams))
+	}
+	for _, s := range streams {
+		s.drain()
+	}
+}
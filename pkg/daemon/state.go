@@ -0,0 +1,155 @@
+package daemon
+
+import "errors"
+
+// ErrNotRunning is returned by Drain and Resume when the daemon isn't serving
+// traffic, because it hasn't finished starting or is shutting down.
+var ErrNotRunning = errors.New("daemon is not running")
+
+// State is a stage in the daemon's lifecycle.
+type State int
+
+const (
+	// StateNew is the state of a daemon that hasn't been run yet.
+	StateNew State = iota
+	// StateStarting means startup hooks are running, services are starting or
+	// startup tasks haven't finished yet.
+	StateStarting
+	// StateReady means everything has started and the daemon is taking traffic.
+	StateReady
+	// StateDraining means the daemon has stopped taking new work, either
+	// because Drain was called or because it has been asked to stop and is
+	// waiting for services to finish their in-flight work.
+	StateDraining
+	// StateStopping means services have stopped and the daemon is canceling
+	// contexts and running shutdown hooks.
+	StateStopping
+	// StateStopped means Run has returned or is about to.
+	StateStopped
+)
+
+func (s State) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateStarting:
+		return "starting"
+	case StateReady:
+		return "ready"
+	case StateDraining:
+		return "draining"
+	case StateStopping:
+		return "stopping"
+	case StateStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// State returns the daemon's current lifecycle state.
+func (d *Daemon) State() State {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.state
+}
+
+// OnStateChange registers fn to be called every time the daemon moves from one
+// lifecycle state to another. Callbacks are called one at a time, in the order
+// the transitions happen, and must not block for long since the daemon waits
+// for them before carrying on.
+func (d *Daemon) OnStateChange(fn func(from, to State)) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	d.stateObservers = append(d.stateObservers, fn)
+}
+
+// Drain takes the daemon out of rotation without shutting it down, e.g. for
+// maintenance or debugging. Readiness starts failing and the daemon moves to
+// StateDraining, so components watching the state can stop picking up new
+// long-lived work. Draining a daemon that is already draining does nothing.
+func (d *Daemon) Drain() error {
+	return d.updateState(func(cur State) (State, error) {
+		if d.shuttingDown || cur != StateReady && cur != StateDraining {
+			return cur, ErrNotRunning
+		}
+		return StateDraining, nil
+	})
+}
+
+// Resume puts a daemon taken out of rotation by Drain back into it. Resuming a
+// daemon that is already ready does nothing, but a daemon that is shutting
+// down can't be resumed.
+func (d *Daemon) Resume() error {
+	return d.updateState(func(cur State) (State, error) {
+		if d.shuttingDown || cur != StateReady && cur != StateDraining {
+			return cur, ErrNotRunning
+		}
+		return StateReady, nil
+	})
+}
+
+// isShuttingDown reports whether the daemon has started shutting down, as
+// opposed to having been drained with Drain.
+func (d *Daemon) isShuttingDown() bool {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.shuttingDown
+}
+
+// hasStarted reports whether the daemon has finished starting up, i.e. has
+// been ready at some point, even if it is now draining or shutting down.
+func (d *Daemon) hasStarted() bool {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.startedUp
+}
+
+// setState moves the daemon to state and notifies observers.
+func (d *Daemon) setState(state State) {
+	d.updateState(func(State) (State, error) {
+		return state, nil
+	})
+}
+
+// beginShutdown moves the daemon to StateDraining for good, so it can no
+// longer be resumed.
+func (d *Daemon) beginShutdown() {
+	d.updateState(func(State) (State, error) {
+		d.shuttingDown = true
+		return StateDraining, nil
+	})
+}
+
+// updateState moves the daemon to the state fn picks based on the current one
+// and notifies observers, unless fn returns an error. Once the daemon has
+// stopped it stays stopped, even if a forced shutdown leaves the graceful path
+// running behind it.
+func (d *Daemon) updateState(fn func(cur State) (State, error)) error {
+	d.notifyMu.Lock()
+	defer d.notifyMu.Unlock()
+
+	d.stateMu.Lock()
+	from := d.state
+	if from == StateStopped {
+		d.stateMu.Unlock()
+		return ErrNotRunning
+	}
+	to, err := fn(from)
+	if err != nil || to == from {
+		d.stateMu.Unlock()
+		return err
+	}
+	d.state = to
+	d.draining.Store(to == StateDraining)
+	if to == StateReady {
+		d.startedUp = true
+	}
+	observers := append([]func(State, State){}, d.stateObservers...)
+	d.stateMu.Unlock()
+
+	for _, fn := range observers {
+		fn(from, to)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"html/template"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/health"
+)
+
+// statusPage is what /status shows, for people poking at an instance during an
+// incident who don't remember which JSON endpoint has what.
+var statusPage = template.Must(template.New("status").Funcs(template.FuncMap{
+	"since": func(t time.Time) time.Duration { return time.Since(t).Round(time.Second) },
+	"ms":    func(d time.Duration) string { return d.Round(time.Microsecond).String() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Path}} status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.ok { color: #080; }
+.fail { color: #c00; }
+</style>
+</head>
+<body>
+<h1>{{.Path}}</h1>
+<table>
+<tr><th>State</th><td>{{.State}}</td></tr>
+<tr><th>Uptime</th><td>{{if .Started.IsZero}}not started{{else}}{{since .Started}}{{end}}</td></tr>
+<tr><th>Connections</th><td>{{.Connections.Active}} active, {{.Connections.Idle}} idle, {{.Connections.WebSockets}} WebSockets, {{.Connections.Rejected}} rejected</td></tr>
+<tr><th>Panics</th><td>{{.Panics}}</td></tr>
+<tr><th>Version</th><td>{{.Version}}</td></tr>
+<tr><th>Go</th><td>{{.GoVersion}}</td></tr>
+{{range .Settings}}<tr><th>{{.Key}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{template "checks" .Readiness}}
+{{template "checks" .Liveness}}
+</body>
+</html>
+{{define "checks"}}<h2>{{.Title}}: {{if .Report.Healthy}}<span class="ok">ok</span>{{else}}<span class="fail">fail</span>{{end}}</h2>
+{{if .Report.NotReady}}<p class="fail">Taken out of rotation.</p>{{end}}
+<table>
+<tr><th>Check</th><th>Status</th><th>Latency</th><th>Error</th></tr>
+{{range .Report.Results}}<tr>
+<td>{{.Name}}{{if .Informational}} (informational){{end}}</td>
+<td>{{if .Disabled}}disabled{{else if .Healthy}}<span class="ok">ok</span>{{else}}<span class="fail">fail</span>{{end}}</td>
+<td>{{ms .Duration}}</td>
+<td>{{with .Err}}{{.}}{{end}}</td>
+</tr>
+{{else}}<tr><td colspan="4">no checks registered</td></tr>
+{{end}}</table>
+{{end}}`))
+
+type statusChecks struct {
+	Title  string
+	Report health.Report
+}
+
+// serveStatus renders an HTML page with the daemon's state, connections, build
+// info and the results of its readiness and liveness checks.
+func (d *Daemon) serveStatus(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Path        string
+		State       State
+		Started     time.Time
+		Connections ConnStats
+		Panics      int64
+		Version     string
+		GoVersion   string
+		Settings    []debug.BuildSetting
+		Readiness   statusChecks
+		Liveness    statusChecks
+	}{
+		Path:        "daemon",
+		State:       d.State(),
+		Started:     d.startTime,
+		Connections: d.Connections(),
+		Panics:      d.Panics(),
+		Version:     d.version,
+		GoVersion:   runtime.Version(),
+		Readiness:   statusChecks{Title: "Readiness", Report: d.health.Check(r.Context())},
+		Liveness:    statusChecks{Title: "Liveness", Report: d.liveness.Check(r.Context())},
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		data.Path = info.Main.Path
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision", "vcs.time", "vcs.modified":
+				data.Settings = append(data.Settings, s)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusPage.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
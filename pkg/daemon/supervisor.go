@@ -0,0 +1,165 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+type restartMode int
+
+const (
+	restartNever restartMode = iota
+	restartOnFailure
+	restartAlways
+)
+
+// RestartPolicy decides whether a supervised goroutine is started again after
+// it returns, and how long to wait before doing so.
+type RestartPolicy struct {
+	mode       restartMode
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// RestartNever never restarts the goroutine. If it returns an error the daemon
+// shuts down.
+var RestartNever = RestartPolicy{mode: restartNever}
+
+// RestartOnFailure restarts the goroutine whenever it returns an error. The
+// delay before restarting starts at minBackoff and doubles after each
+// consecutive failure up to maxBackoff, and is reset once the goroutine has
+// stayed up for longer than maxBackoff.
+func RestartOnFailure(minBackoff, maxBackoff time.Duration) RestartPolicy {
+	return RestartPolicy{mode: restartOnFailure, minBackoff: minBackoff, maxBackoff: maxBackoff}
+}
+
+// RestartAlways restarts the goroutine whenever it returns, with the same
+// backoff as RestartOnFailure.
+func RestartAlways(minBackoff, maxBackoff time.Duration) RestartPolicy {
+	return RestartPolicy{mode: restartAlways, minBackoff: minBackoff, maxBackoff: maxBackoff}
+}
+
+// Supervise runs fn in a goroutine managed by the daemon, restarting it
+// according to policy. fn is given the daemon's root context and must return
+// once it is done. Goroutines supervised before Run are started once all
+// services have started; afterwards they start immediately. A panic in fn is
+// recovered and treated as fn returning a *PanicError. If fn returns an error
+// that policy won't restart, the daemon shuts down and Run returns it.
+func (d *Daemon) Supervise(name string, policy RestartPolicy, fn func(ctx context.Context) error) {
+	d.supervisor.add(supervised{name: name, policy: policy, fn: fn, fatal: true})
+}
+
+// supervised is a goroutine run by the supervisor. If fatal is set, an error
+// that won't be restarted makes the daemon shut down, otherwise it's logged.
+type supervised struct {
+	name   string
+	policy RestartPolicy
+	fn     func(ctx context.Context) error
+	fatal  bool
+}
+
+// supervisor holds the goroutines registered with Supervise and Go until the
+// daemon starts them, and refuses new ones once it has been stopped.
+type supervisor struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	fail    func(error)
+	track   func() func()
+	pending []supervised
+	stopped bool
+}
+
+// start runs every pending goroutine with ctx and any added later, reporting
+// the errors that won't be restarted to fail. Each goroutine is registered
+// with track while it runs.
+func (s *supervisor) start(ctx context.Context, fail func(error), track func() func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx = ctx
+	s.fail = fail
+	s.track = track
+	for _, g := range s.pending {
+		s.spawn(g)
+	}
+	s.pending = nil
+}
+
+func (s *supervisor) add(g supervised) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		httpmw.LoggerFromContext(s.ctx).Warn("not starting goroutine, the daemon has stopped", "goroutine", g.name)
+		return
+	}
+	if s.ctx == nil {
+		s.pending = append(s.pending, g)
+		return
+	}
+	s.spawn(g)
+}
+
+// stop makes add drop the goroutines added from now on rather than start them,
+// so none is registered with track once the daemon has started waiting for
+// the tracked ones to return.
+func (s *supervisor) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+}
+
+func (s *supervisor) spawn(g supervised) {
+	done := s.track()
+	go func() {
+		defer done()
+		s.run(g)
+	}()
+}
+
+func (s *supervisor) run(g supervised) {
+	backoff := g.policy.minBackoff
+	for {
+		started := time.Now()
+		err := g.call(s.ctx)
+		// returning because the daemon is shutting down is never a failure
+		if s.ctx.Err() != nil {
+			return
+		}
+		restart := g.policy.mode == restartAlways || g.policy.mode == restartOnFailure && err != nil
+		if !restart {
+			var panicErr *PanicError
+			switch {
+			case err == nil:
+			case g.fatal:
+				s.fail(fmt.Errorf("goroutine %q: %w", g.name, err))
+			case errors.As(err, &panicErr):
+				// already logged along with its stack
+			default:
+				httpmw.LoggerFromContext(s.ctx).Error("goroutine failed", "goroutine", g.name, "err", err)
+			}
+			return
+		}
+
+		if time.Since(started) > g.policy.maxBackoff {
+			backoff = g.policy.minBackoff
+		}
+		if err != nil {
+			httpmw.LoggerFromContext(s.ctx).Warn("goroutine failed, restarting", "goroutine", g.name, "backoff", backoff, "err", err)
+		} else {
+			httpmw.LoggerFromContext(s.ctx).Info("goroutine returned, restarting", "goroutine", g.name, "backoff", backoff)
+		}
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-s.ctx.Done():
+			t.Stop()
+			return
+		}
+		backoff = min(backoff*2, g.policy.maxBackoff)
+	}
+}
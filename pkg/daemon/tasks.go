@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"context"
+	"time"
+)
+
+type startupTask struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// StartupTask registers fn to run in the background once all services have
+// started, e.g. to warm a cache that needs the servers to be up. The daemon
+// stays in StateStarting, with /readiness and /startup failing, until every
+// startup task has returned, so it doesn't advertise itself as ready while
+// they're still working. If a task returns an error or panics, the daemon shuts
+// down and Run returns the error. Startup tasks must be registered before Run
+// is called.
+func (d *Daemon) StartupTask(name string, fn func(ctx context.Context) error) {
+	d.tasksMu.Lock()
+	defer d.tasksMu.Unlock()
+	d.startupTasks = append(d.startupTasks, startupTask{name: name, fn: fn})
+}
+
+// runStartupTasks starts the startup tasks under the supervisor and moves the
+// daemon to StateReady once they have all finished, or right away if there
+// aren't any.
+func (d *Daemon) runStartupTasks() {
+	d.tasksMu.Lock()
+	tasks := d.startupTasks
+	d.tasksRemaining = len(tasks)
+	d.tasksMu.Unlock()
+
+	if len(tasks) == 0 {
+		d.setState(StateReady)
+		return
+	}
+	for _, t := range tasks {
+		d.supervisor.add(supervised{
+			name:   t.name,
+			policy: RestartNever,
+			fn: func(ctx context.Context) error {
+				start := time.Now()
+				err := t.fn(ctx)
+				d.emit(Event{Kind: EventStartupTaskFinished, Name: t.name, Duration: time.Since(start), Err: err})
+				if err == nil {
+					d.finishStartupTask()
+				}
+				return err
+			},
+			fatal: true,
+		})
+	}
+}
+
+// finishStartupTask records that a startup task has succeeded, moving the
+// daemon to StateReady if it was the last one.
+func (d *Daemon) finishStartupTask() {
+	d.tasksMu.Lock()
+	d.tasksRemaining--
+	remaining := d.tasksRemaining
+	d.tasksMu.Unlock()
+	if remaining > 0 {
+		return
+	}
+	d.updateState(func(cur State) (State, error) {
+		// don't advertise ourselves as ready if we've started shutting down
+		if cur != StateStarting || d.shuttingDown {
+			return cur, nil
+		}
+		return StateReady, nil
+	})
+}
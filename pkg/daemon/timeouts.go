@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+
+	// writeTimeoutMargin is how much longer than the route timeout the read and
+	// write timeouts default to, so a handler that runs right up to the route
+	// timeout still has time to write its response
+	writeTimeoutMargin = 5 * time.Second
+)
+
+// serverTimeouts are the timeouts the daemon gives its HTTP servers. Zero means
+// the default, and a negative value means no timeout, as with http.Server.
+type serverTimeouts struct {
+	readHeader time.Duration
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+}
+
+// WithReadHeaderTimeout sets how long clients of the main server have to send
+// the headers of a request, which stops slowloris attacks from tying up
+// connections. It defaults to 5 seconds. Like the other server timeouts, it
+// also applies to servers added with AddServer that leave it unset, and a
+// negative value turns it off.
+func WithReadHeaderTimeout(timeout time.Duration) Option {
+	return func(d *Daemon) {
+		d.timeouts.readHeader = timeout
+	}
+}
+
+// WithReadTimeout sets how long clients of the main server have to send a
+// whole request, including the body. It defaults to the route timeout plus 5
+// seconds.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(d *Daemon) {
+		d.timeouts.read = timeout
+	}
+}
+
+// WithWriteTimeout sets how long the main server has to write a response once
+// it has read the request headers. It defaults to the route timeout plus 5
+// seconds, and must be raised or turned off for responses that stream for
+// longer.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(d *Daemon) {
+		d.timeouts.write = timeout
+	}
+}
+
+// WithIdleTimeout sets how long the main server keeps an idle keep-alive
+// connection open waiting for the next request. It defaults to 2 minutes.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(d *Daemon) {
+		d.timeouts.idle = timeout
+	}
+}
+
+// applyTimeouts sets each timeout s leaves unset to the one configured for the
+// daemon, or its default.
+func (d *Daemon) applyTimeouts(s *http.Server) {
+	t := d.timeouts
+	if t.readHeader == 0 {
+		t.readHeader = defaultReadHeaderTimeout
+	}
+	if t.read == 0 {
+		t.read = d.longestRouteTimeout() + writeTimeoutMargin
+	}
+	if t.write == 0 {
+		t.write = d.longestRouteTimeout() + writeTimeoutMargin
+	}
+	if t.idle == 0 {
+		t.idle = defaultIdleTimeout
+	}
+
+	if s.ReadHeaderTimeout == 0 {
+		s.ReadHeaderTimeout = t.readHeader
+	}
+	if s.ReadTimeout == 0 {
+		s.ReadTimeout = t.read
+	}
+	if s.WriteTimeout == 0 {
+		s.WriteTimeout = t.write
+	}
+	if s.IdleTimeout == 0 {
+		s.IdleTimeout = t.idle
+	}
+}
+
+// longestRouteTimeout returns the longest of the route timeout and its
+// overrides.
+func (d *Daemon) longestRouteTimeout() time.Duration {
+	longest := d.routeTimeout
+	for _, route := range d.routeTimeouts {
+		longest = max(longest, route.Timeout)
+	}
+	return longest
+}
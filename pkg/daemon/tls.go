@@ -0,0 +1,157 @@
+package daemon
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+const defaultCertWatchInterval = 30 * time.Second
+
+// CertReloader serves a TLS certificate loaded from a pair of PEM files and
+// reloads it when asked, so certificates can be rotated without restarting the
+// daemon. It is a Reloader, so adding it with AddReloader reloads it on SIGHUP,
+// and Watch reloads it whenever the files change. Nothing is loaded until the
+// first Reload.
+type CertReloader struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	previous *tls.Certificate
+	// modTimes are the modification times of the files the current certificate
+	// was loaded from
+	modTimes [2]time.Time
+}
+
+// NewCertReloader returns a CertReloader for the certificate in certFile and
+// its key in keyFile.
+func NewCertReloader(certFile, keyFile string) *CertReloader {
+	return &CertReloader{certFile: certFile, keyFile: keyFile}
+}
+
+// TLSConfig returns a server TLS config that serves the current certificate,
+// for use as an http.Server's TLSConfig.
+func (c *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
+
+// GetCertificate returns the current certificate, for use as a tls.Config's
+// GetCertificate.
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cert == nil {
+		return nil, fmt.Errorf("certificate %s hasn't been loaded", c.certFile)
+	}
+	return c.cert, nil
+}
+
+// Reload loads the certificate from its files again. If they can't be loaded,
+// e.g. because they are only half written, the current certificate is kept.
+func (c *CertReloader) Reload(ctx context.Context) error {
+	modTimes, err := c.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.previous, c.cert = c.cert, &cert
+	c.modTimes = modTimes
+	return nil
+}
+
+// Rollback goes back to the certificate from before the last Reload.
+func (c *CertReloader) Rollback(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.previous != nil {
+		c.cert = c.previous
+	}
+	return nil
+}
+
+// Watch checks the files every interval and reloads the certificate when
+// either has changed, until ctx is done. Failed reloads are logged to ctx's
+// logger, see httpmw.LoggerFromContext, and retried at the next check.
+func (c *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	logger := httpmw.LoggerFromContext(ctx)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		modTimes, err := c.stat()
+		if err != nil {
+			logger.Warn("checking certificate failed", "cert", c.certFile, "err", err)
+			continue
+		}
+		c.mu.Lock()
+		changed := modTimes != c.modTimes
+		c.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if err := c.Reload(ctx); err != nil {
+			logger.Error("reloading certificate failed", "cert", c.certFile, "err", err)
+			continue
+		}
+		logger.Info("reloaded certificate", "cert", c.certFile)
+	}
+}
+
+func (c *CertReloader) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, name := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = fi.ModTime()
+	}
+	return modTimes, nil
+}
+
+// WithTLS makes the main server serve HTTPS with the certificate in certFile
+// and its key in keyFile. The certificate is loaded before the startup hooks
+// run, and reloaded on SIGHUP and whenever the files change, which is checked
+// every interval, or every 30 seconds if interval is 0.
+func WithTLS(certFile, keyFile string, interval time.Duration) Option {
+	return func(d *Daemon) {
+		if interval <= 0 {
+			interval = defaultCertWatchInterval
+		}
+		d.tls = NewCertReloader(certFile, keyFile)
+		d.tlsWatchInterval = interval
+	}
+}
+
+// setupTLS registers what the main server's certificate needs to be loaded up
+// front and kept current.
+func (d *Daemon) setupTLS() {
+	if d.tls == nil || d.autocert != nil {
+		return
+	}
+	// this is the first startup hook, so a bad certificate stops us before
+	// anything else starts
+	d.OnStartup("tls certificate", d.tls.Reload, nil)
+	d.AddReloader("tls certificate", d.tls)
+	d.Go("tls certificate watcher", func(ctx context.Context) {
+		d.tls.Watch(ctx, d.tlsWatchInterval)
+	})
+}
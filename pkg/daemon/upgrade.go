@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+// ErrUpgraded is the cause of a shutdown started by Upgrade once the new
+// process has taken over.
+var ErrUpgraded = errors.New("upgraded to a new process")
+
+// ErrUpgradeUnsupported is returned by Upgrade on platforms that can't hand
+// listeners to another process.
+var ErrUpgradeUnsupported = errors.New("upgrades are not supported on this platform")
+
+// envListenAddrs tells a process started by Upgrade the addresses of the
+// listeners it inherited. The pipe used to report that it is ready is passed
+// as fd 3 and the listeners follow it in the same order.
+const envListenAddrs = "DAEMON_LISTEN_ADDRS"
+
+const upgradeReadyFD = 3
+
+// inherited holds what a process started by Upgrade was handed by the old one.
+var inherited struct {
+	once      sync.Once
+	mu        sync.Mutex
+	listeners map[string]io.Closer
+	ready     *os.File
+}
+
+// inherit picks up the listeners and ready pipe handed over by the old process,
+// if any, logging the ones it can't use to logger. It only does anything the
+// first time it is called.
+func inherit(logger *slog.Logger) {
+	inherited.once.Do(func() {
+		v, ok := os.LookupEnv(envListenAddrs)
+		if !ok {
+			return
+		}
+		// don't hand these down to processes started by the application
+		os.Unsetenv(envListenAddrs)
+
+		var addrs []string
+		if err := json.Unmarshal([]byte(v), &addrs); err != nil {
+			logger.Warn("ignoring inherited listeners", "err", err)
+			return
+		}
+		inherited.ready = os.NewFile(upgradeReadyFD, "upgrade ready")
+		inherited.listeners = make(map[string]io.Closer, len(addrs))
+		for i, addr := range addrs {
+			f := os.NewFile(uintptr(upgradeReadyFD+1+i), addr)
+			ln, err := fileListener(addr, f)
+			f.Close()
+			if err != nil {
+				logger.Warn("ignoring inherited listener", "addr", addr, "err", err)
+				continue
+			}
+			inherited.listeners[addr] = ln
+		}
+	})
+}
+
+// fileListener makes a listener, or a UDP socket if addr starts with "udp:",
+// from a file descriptor handed over by the old process.
+func fileListener(addr string, f *os.File) (io.Closer, error) {
+	if strings.HasPrefix(addr, "udp:") {
+		return net.FilePacketConn(f)
+	}
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+	// the new process owns the socket now, so it removes it when it stops
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(true)
+	}
+	return ln, nil
+}
+
+// inheritedListener returns the listener or UDP socket for addr handed over by
+// the old process, or nil if there isn't one. Each is only returned once.
+func inheritedListener(ctx context.Context, addr string) io.Closer {
+	inherit(httpmw.LoggerFromContext(ctx))
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	ln := inherited.listeners[addr]
+	delete(inherited.listeners, addr)
+	return ln
+}
+
+// upgradeReady tells the old process that this one is ready to take over, and
+// closes the inherited listeners that nothing has used since the addresses are
+// no longer configured.
+func upgradeReady() {
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	if inherited.ready == nil {
+		return
+	}
+	inherited.ready.Write([]byte{1})
+	inherited.ready.Close()
+	inherited.ready = nil
+	for addr, ln := range inherited.listeners {
+		ln.Close()
+		delete(inherited.listeners, addr)
+	}
+}
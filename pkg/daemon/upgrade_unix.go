@@ -0,0 +1,141 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+)
+
+// Upgrade starts a new copy of the process, from the current executable and
+// with the same arguments, and hands it the listeners of every server, so the
+// new binary can take over without refusing a single connection. Once the new
+// process has started up and become ready, the daemon shuts down gracefully
+// with ErrUpgraded as the cause, draining the requests it is still serving
+// while the new process accepts new ones.
+//
+// If the new process exits before becoming ready or ctx is done first, it is
+// killed and the daemon carries on as before. Upgrade is meant for deployments
+// that run the binary directly rather than under an orchestrator that replaces
+// whole instances, and is typically bound to a signal:
+//
+//	d.HandleSignal(syscall.SIGUSR2, func(ctx context.Context) {
+//		d.Upgrade(ctx)
+//	})
+//
+// Whatever supervises the process must not take the old process exiting as
+// the service having stopped, since the new process carries on under a
+// different PID.
+func (d *Daemon) Upgrade(ctx context.Context) error {
+	d.upgradeMu.Lock()
+	defer d.upgradeMu.Unlock()
+	if state := d.State(); state != StateReady && state != StateDraining || d.isShuttingDown() {
+		return ErrNotRunning
+	}
+
+	addrs, files, err := listenerFiles()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("upgrading: %w", err)
+	}
+	encoded, err := json.Marshal(addrs)
+	if err != nil {
+		return fmt.Errorf("upgrading: %w", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("upgrading: %w", err)
+	}
+
+	// the new process writes to the pipe once it's ready, and it's closed
+	// without anything written if the process exits first
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("upgrading: %w", err)
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), envListenAddrs+"="+string(encoded))
+	cmd.ExtraFiles = append([]*os.File{w}, files...)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return fmt.Errorf("upgrading: %w", err)
+	}
+	d.logger.Info("upgrading: started new process", "pid", cmd.Process.Pid)
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+		if err != nil {
+			err = errors.New("new process exited before becoming ready")
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		err = fmt.Errorf("upgrading: %w", err)
+		d.logger.Error("upgrade failed", "pid", cmd.Process.Pid, "err", err)
+		return err
+	}
+
+	// the sockets belong to the new process now, so closing our listeners on the
+	// way down mustn't remove them
+	keepSockets()
+	d.logger.Info("upgrading: new process is ready", "pid", cmd.Process.Pid)
+	d.requestStop(ErrUpgraded)
+	return nil
+}
+
+// listenerFiles returns the addresses of the open listeners and a duplicate of
+// each one's file descriptor, in the same order.
+func listenerFiles() ([]string, []*os.File, error) {
+	openListeners.mu.Lock()
+	defer openListeners.mu.Unlock()
+	var addrs []string
+	var files []*os.File
+	for addr, ln := range openListeners.m {
+		filer, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, files, fmt.Errorf("listener for %s can't be handed over", addr)
+		}
+		f, err := filer.File()
+		if err != nil {
+			return nil, files, fmt.Errorf("listener for %s: %w", addr, err)
+		}
+		addrs = append(addrs, addr)
+		files = append(files, f)
+	}
+	return addrs, files, nil
+}
+
+// keepSockets stops the open Unix socket listeners from removing their socket
+// files when they are closed.
+func keepSockets() {
+	openListeners.mu.Lock()
+	defer openListeners.mu.Unlock()
+	for _, ln := range openListeners.m {
+		if ul, ok := ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package daemon
+
+import "context"
+
+// Upgrade would hand the daemon's listeners to a new copy of the process, but
+// Windows processes can't inherit sockets that way, so it always returns
+// ErrUpgradeUnsupported.
+func (d *Daemon) Upgrade(ctx context.Context) error {
+	return ErrUpgradeUnsupported
+}
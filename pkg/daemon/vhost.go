@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HandleHost serves the main server's requests for host with h instead of the
+// main handler, so one daemon can serve api.example.com and admin.example.com
+// from separate muxes with a shared lifecycle. host is matched against the
+// request's Host header, ignoring case and any port, and may start with "*." to
+// match every subdomain of a name, as in "*.example.com". An exact name wins
+// over a wildcard, and the longest wildcard over shorter ones. Requests for
+// other hosts go to the main handler, or get a 404 if New was given a nil
+// handler, in which case HandleHost is what makes the daemon run a main
+// server. Hosts must be added before calling Run.
+func (d *Daemon) HandleHost(host string, h http.Handler) {
+	d.servicesMu.Lock()
+	defer d.servicesMu.Unlock()
+	if d.hosts == nil {
+		d.hosts = make(map[string]http.Handler)
+	}
+	d.hosts[strings.ToLower(host)] = h
+}
+
+// mainHandler returns the handler for the main server, which routes requests
+// to the handlers added with HandleHost before falling back to the main
+// handler. It must be called with servicesMu held.
+func (d *Daemon) mainHandler() http.Handler {
+	fallback := d.handler
+	if fallback == nil {
+		fallback = http.NotFoundHandler()
+	}
+	if len(d.hosts) == 0 {
+		return fallback
+	}
+	hosts := make(map[string]http.Handler, len(d.hosts))
+	for host, h := range d.hosts {
+		hosts[host] = h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h := matchHost(hosts, r.Host); h != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
+
+// matchHost returns the handler for host, trying the host itself and then
+// wildcards for each of its parent domains in turn, or nil if there's none.
+func matchHost(hosts map[string]http.Handler, host string) http.Handler {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if h, ok := hosts[host]; ok {
+		return h
+	}
+	for {
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return nil
+		}
+		if h, ok := hosts["*"+host[i:]]; ok {
+			return h
+		}
+		host = host[i+1:]
+	}
+}
@@ -0,0 +1,107 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+)
+
+// WebSocket is a WebSocket connection the daemon closes gracefully when it
+// shuts down. http.Server.Shutdown leaves hijacked connections alone, so
+// without this they stay open until the process exits and clients see them
+// drop without a close frame.
+type WebSocket interface {
+	// GoingAway starts the closing handshake, typically by sending a close
+	// frame with status 1001 (going away). It must be safe to call while
+	// other goroutines read from and write to the connection.
+	GoingAway() error
+	// Close closes the connection right away.
+	Close() error
+}
+
+// AddWebSocket registers ws to be closed when the daemon shuts down, and
+// returns a func that must be called once the connection has ended, however it
+// ended. While draining, the daemon calls GoingAway on every registered
+// connection and waits for them to end, calling Close on the ones still open
+// once the shutdown timeout has passed. Connections registered after the
+// shutdown has started are told to go away right away.
+//
+// With gorilla/websocket, for example:
+//
+//	type goingAway struct{ *websocket.Conn }
+//
+//	func (c goingAway) GoingAway() error {
+//		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+//		return c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+//	}
+//
+//	done := d.AddWebSocket(goingAway{conn})
+//	defer done()
+func (d *Daemon) AddWebSocket(ws WebSocket) (done func()) {
+	s := &webSocket{ws: ws, done: make(chan struct{})}
+	d.webSocketsMu.Lock()
+	if d.webSockets == nil {
+		d.webSockets = make(map[*webSocket]struct{})
+	}
+	d.webSockets[s] = struct{}{}
+	closing := d.closingWebSockets
+	d.webSocketsMu.Unlock()
+	if closing {
+		go s.goAway()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.webSocketsMu.Lock()
+			delete(d.webSockets, s)
+			d.webSocketsMu.Unlock()
+			close(s.done)
+		})
+	}
+}
+
+type webSocket struct {
+	ws   WebSocket
+	done chan struct{}
+}
+
+// goAway starts the closing handshake, closing the connection outright if
+// that fails.
+func (s *webSocket) goAway() {
+	if err := s.ws.GoingAway(); err != nil {
+		s.ws.Close()
+	}
+}
+
+func (d *Daemon) openWebSockets() int {
+	d.webSocketsMu.Lock()
+	defer d.webSocketsMu.Unlock()
+	return len(d.webSockets)
+}
+
+// closeWebSockets tells every registered WebSocket to go away and waits for
+// them to end, closing the ones still open once ctx is done.
+func (d *Daemon) closeWebSockets(ctx context.Context) {
+	d.webSocketsMu.Lock()
+	d.closingWebSockets = true
+	sockets := make([]*webSocket, 0, len(d.webSockets))
+	for s := range d.webSockets {
+		sockets = append(sockets, s)
+	}
+	d.webSocketsMu.Unlock()
+	if len(sockets) == 0 {
+		return
+	}
+
+	d.logger.Info("closing WebSocket connections", "count", len(sockets))
+	for _, s := range sockets {
+		s.goAway()
+	}
+	for _, s := range sockets {
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			s.ws.Close()
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package daemon
+
+import "context"
+
+// RunWindowsService runs the daemon under the Windows Service Control Manager
+// when the process was started as a Windows service. On other platforms it is
+// the same as Run.
+func (d *Daemon) RunWindowsService(ctx context.Context, name string) error {
+	return d.Run(ctx)
+}
@@ -0,0 +1,97 @@
+//go:build windows
+
+package daemon
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// ErrServiceStopRequested is the shutdown cause when the Windows Service
+// Control Manager asks the service to stop, or the system is shutting down.
+var ErrServiceStopRequested = errors.New("stop requested by the service control manager")
+
+// RunWindowsService runs the daemon under the Windows Service Control Manager
+// when the process was started as a Windows service, and is the same as Run
+// otherwise. The service reports running once the daemon is ready, stops
+// gracefully when asked to stop or when the system shuts down, and maps pause
+// and continue requests to Drain and Resume. A non-zero ExitCode is reported
+// to the SCM as the service-specific exit code.
+func (d *Daemon) RunWindowsService(ctx context.Context, name string) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return d.Run(ctx)
+	}
+
+	h := &serviceHandler{d: d, ctx: ctx}
+	if err := svc.Run(name, h); err != nil {
+		return err
+	}
+	return h.err
+}
+
+// serviceHandler runs a Daemon as an svc.Handler.
+type serviceHandler struct {
+	d   *Daemon
+	ctx context.Context
+	err error
+}
+
+const serviceAccepts = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	// translate the daemon's lifecycle into service states as it happens
+	states := make(chan svc.State, 8)
+	h.d.OnStateChange(func(from, to State) {
+		switch to {
+		case StateReady:
+			states <- svc.Running
+		case StateDraining:
+			if h.d.isShuttingDown() {
+				states <- svc.StopPending
+			} else {
+				states <- svc.Paused
+			}
+		}
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.d.Run(h.ctx)
+	}()
+
+	current := svc.Status{State: svc.StartPending}
+	for {
+		select {
+		case state := <-states:
+			current = svc.Status{State: state, Accepts: serviceAccepts}
+			if state == svc.StopPending {
+				current.Accepts = 0
+			}
+			status <- current
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				h.d.requestStop(ErrServiceStopRequested)
+			case svc.Pause:
+				h.d.Drain()
+			case svc.Continue:
+				h.d.Resume()
+			}
+		case err := <-done:
+			h.err = err
+			status <- svc.Status{State: svc.StopPending}
+			code := ExitCode(err)
+			return code != ExitOK, uint32(code)
+		}
+	}
+}
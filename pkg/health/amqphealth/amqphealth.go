@@ -0,0 +1,45 @@
+// Package amqphealth provides a health.Checker for AMQP 0-9-1 brokers such as
+// RabbitMQ, using the connection the application already has:
+//
+//	d.Health().Register("rabbitmq", amqphealth.Checker(conn, 2*time.Second))
+package amqphealth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/forgeutah/utah-go/pkg/health"
+)
+
+// Checker returns a Checker that fails unless conn is open and the broker
+// opens a channel on it within timeout. The channel is closed again right
+// away.
+func Checker(conn *amqp.Connection, timeout time.Duration) health.Checker {
+	return health.CheckerFunc(func(ctx context.Context) error {
+		if conn.IsClosed() {
+			return errors.New("connection is closed")
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		// opening a channel doesn't take a context, so give up on it rather than
+		// wait, and close it whenever it does open
+		done := make(chan error, 1)
+		go func() {
+			ch, err := conn.Channel()
+			if err == nil {
+				err = ch.Close()
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotChecked is the error reported for a background check that hasn't
+// finished running for the first time yet.
+var ErrNotChecked = errors.New("health: not checked yet")
+
+// Run runs the checks registered with Background, each on its own interval,
+// until ctx is done. Each check is first run as soon as Run starts or it is
+// registered, and reports ErrNotChecked until then.
+func (r *Registry) Run(ctx context.Context) {
+	r.mu.Lock()
+	r.ctx = ctx
+	for _, c := range r.checks {
+		if c.cache != nil {
+			r.startLocked(c)
+		}
+	}
+	r.mu.Unlock()
+
+	<-ctx.Done()
+
+	r.mu.Lock()
+	r.ctx = nil
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// startLocked starts running c in the background. r.mu must be held.
+func (r *Registry) startLocked(c check) {
+	ctx, cancel := context.WithCancel(r.ctx)
+	c.cache.mu.Lock()
+	c.cache.cancel = cancel
+	c.cache.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer cancel()
+		t := time.NewTicker(c.interval)
+		defer t.Stop()
+		for {
+			res := c.run(ctx)
+			// a result cut short by the check being stopped isn't worth keeping
+			if ctx.Err() != nil {
+				return
+			}
+			r.record(res)
+			res = c.damp(res)
+			c.cache.set(res)
+			r.observe(res)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop stops running c in the background, if it is.
+func (c check) stop() {
+	if c.cache == nil {
+		return
+	}
+	c.cache.mu.Lock()
+	defer c.cache.mu.Unlock()
+	if c.cache.cancel != nil {
+		c.cache.cancel()
+	}
+}
+
+// cache holds the latest result of a background check.
+type cache struct {
+	mu      sync.Mutex
+	res     Result
+	checked bool
+	cancel  context.CancelFunc
+}
+
+func (c *cache) set(res Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.res = res
+	c.checked = true
+}
+
+func (c *cache) result(ch check) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checked {
+		return Result{
+			Name:          ch.name,
+			Err:           ErrNotChecked,
+			Informational: ch.informational,
+			InGracePeriod: ch.grace.active(),
+		}
+	}
+	return c.res
+}
@@ -0,0 +1,34 @@
+package health
+
+import (
+	"context"
+	"fmt"
+)
+
+// BacklogChecker returns a Checker that fails while depth reports more than
+// max items waiting, e.g. the length of a work queue or the number of jobs
+// not yet picked up. An overloaded instance then sheds traffic through the
+// load balancer instead of timing requests out. It reports the depth and max as
+// details.
+func BacklogChecker(depth func() int, max int) Checker {
+	return &backlogChecker{depth: depth, max: max}
+}
+
+type backlogChecker struct {
+	depth func() int
+	max   int
+}
+
+func (c *backlogChecker) Check(ctx context.Context) error {
+	if n := c.depth(); n > c.max {
+		return fmt.Errorf("backlog of %d is over %d", n, c.max)
+	}
+	return nil
+}
+
+func (c *backlogChecker) Details() map[string]any {
+	return map[string]any{
+		"depth": c.depth(),
+		"max":   c.max,
+	}
+}
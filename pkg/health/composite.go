@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// NamedChecker is a Checker that is part of a composite check made with AllOf
+// or AnyOf.
+type NamedChecker struct {
+	Name    string
+	Checker Checker
+}
+
+// Named names c for use in AllOf or AnyOf.
+func Named(name string, c Checker) NamedChecker {
+	return NamedChecker{Name: name, Checker: c}
+}
+
+// AllOf returns a Checker that only passes if every one of checkers passes.
+func AllOf(checkers ...NamedChecker) Checker {
+	return &composite{checkers: checkers, any: false}
+}
+
+// AnyOf returns a Checker that passes if at least one of checkers does, so
+// redundant dependencies, such as a primary database and its replica, only
+// fail readiness when all of them are down:
+//
+//	reg.Register("db", health.AnyOf(
+//		health.Named("primary", health.SQLChecker(primary)),
+//		health.Named("replica", health.SQLChecker(replica)),
+//	))
+func AnyOf(checkers ...NamedChecker) Checker {
+	return &composite{checkers: checkers, any: true}
+}
+
+// composite runs its checkers concurrently and combines their results. It
+// reports each checker's result as details.
+type composite struct {
+	checkers []NamedChecker
+	any      bool
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func (c *composite) Check(ctx context.Context) error {
+	errs := make([]error, len(c.checkers))
+	var wg sync.WaitGroup
+	for i, nc := range c.checkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = nc.Checker.Check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	c.errs = errs
+	c.mu.Unlock()
+
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", c.checkers[i].Name, err))
+		}
+	}
+	if len(failed) == 0 || c.any && len(failed) < len(errs) {
+		return nil
+	}
+	return errors.Join(failed...)
+}
+
+func (c *composite) Details() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	details := make(map[string]any, len(c.checkers))
+	for i, nc := range c.checkers {
+		switch {
+		case i >= len(c.errs):
+		case c.errs[i] != nil:
+			details[nc.Name] = c.errs[i].Error()
+		default:
+			details[nc.Name] = "ok"
+		}
+	}
+	return details
+}
@@ -0,0 +1,48 @@
+package health
+
+import (
+	"fmt"
+	"sync"
+)
+
+// damping tracks the streak of results of a check registered with
+// FailureThreshold or SuccessThreshold.
+type damping struct {
+	mu      sync.Mutex
+	down    bool
+	streak  int
+	lastErr error
+}
+
+// damp turns the result of a run of c into the result the check counts as,
+// taking its thresholds into account.
+func (c check) damp(res Result) Result {
+	d := c.damping
+	if d == nil {
+		return res
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// the streak counts the runs that disagree with the current state
+	failed := res.Err != nil
+	if failed != d.down {
+		d.streak++
+	} else {
+		d.streak = 0
+	}
+	if failed {
+		d.lastErr = res.Err
+	}
+
+	switch {
+	case !d.down && failed && d.streak < c.failureThreshold:
+		res.Pending, res.Err = res.Err, nil
+	case d.down && !failed && d.streak < c.successThreshold:
+		res.Err = fmt.Errorf("recovering, %d of %d successes: %w", d.streak, c.successThreshold, d.lastErr)
+	case failed != d.down:
+		d.down = failed
+		d.streak = 0
+	}
+	return res
+}
@@ -0,0 +1,74 @@
+package health
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUnknownCheck is returned when there is no check registered under the name
+// given.
+var ErrUnknownCheck = errors.New("health: unknown check")
+
+// Disable turns off the check registered under name, e.g. while the
+// dependency it checks is down for planned maintenance. A disabled check isn't
+// run and counts as healthy. If duration is positive the check turns itself
+// back on after that long, otherwise it stays off until Enable is called.
+func (r *Registry) Disable(name string, duration time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.registeredLocked(name) {
+		return ErrUnknownCheck
+	}
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+	if r.disabled == nil {
+		r.disabled = make(map[string]time.Time)
+	}
+	r.disabled[name] = until
+	return nil
+}
+
+// Enable turns a check turned off with Disable back on.
+func (r *Registry) Enable(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.registeredLocked(name) {
+		return ErrUnknownCheck
+	}
+	delete(r.disabled, name)
+	return nil
+}
+
+// Disabled returns the checks that are turned off, along with when each is
+// turned back on, or the zero time if it stays off until Enable is called.
+func (r *Registry) Disabled() map[string]time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.disabledLocked()
+}
+
+// disabledLocked drops the checks whose time is up from r.disabled and returns
+// a copy of the rest. r.mu must be held.
+func (r *Registry) disabledLocked() map[string]time.Time {
+	now := time.Now()
+	disabled := make(map[string]time.Time, len(r.disabled))
+	for name, until := range r.disabled {
+		if !until.IsZero() && now.After(until) {
+			delete(r.disabled, name)
+			continue
+		}
+		disabled[name] = until
+	}
+	return disabled
+}
+
+func (r *Registry) registeredLocked(name string) bool {
+	for _, c := range r.checks {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DiskChecker returns a Checker that fails when the filesystem holding path
+// has less than minFree bytes available to the process. It reports the free
+// and total bytes as details.
+func DiskChecker(path string, minFree uint64) Checker {
+	return &diskChecker{path: path, minFree: minFree}
+}
+
+type diskChecker struct {
+	path    string
+	minFree uint64
+
+	mu          sync.Mutex
+	free, total uint64
+}
+
+func (c *diskChecker) Check(ctx context.Context) error {
+	free, total, err := diskUsage(c.path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.free, c.total = free, total
+	c.mu.Unlock()
+
+	if free < c.minFree {
+		return fmt.Errorf("%s has %d bytes free, want at least %d", c.path, free, c.minFree)
+	}
+	return nil
+}
+
+func (c *diskChecker) Details() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]any{
+		"path":        c.path,
+		"free_bytes":  c.free,
+		"total_bytes": c.total,
+	}
+}
@@ -0,0 +1,26 @@
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+// grace tracks a check's grace period, which starts the first time active is
+// called.
+type grace struct {
+	period time.Duration
+	once   sync.Once
+	until  time.Time
+}
+
+// active reports whether the grace period is still running. A nil grace never
+// is.
+func (g *grace) active() bool {
+	if g == nil {
+		return false
+	}
+	g.once.Do(func() {
+		g.until = time.Now().Add(g.period)
+	})
+	return time.Now().Before(g.until)
+}
@@ -0,0 +1,117 @@
+// Package grpchealth implements the gRPC Health Checking Protocol on top of a
+// health.Registry, so Kubernetes gRPC probes and Envoy health checks see the
+// same result as the HTTP readiness endpoint:
+//
+//	srv := grpc.NewServer()
+//	grpc_health_v1.RegisterHealthServer(srv, grpchealth.NewServer(d.Health()))
+//
+// The empty service name reports the registry as a whole, and any other name
+// reports the check registered under it.
+package grpchealth
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/forgeutah/utah-go/pkg/health"
+)
+
+const defaultWatchInterval = 5 * time.Second
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithWatchInterval sets how often Watch checks the registry for changes to
+// send to the client. It defaults to 5 seconds.
+func WithWatchInterval(interval time.Duration) Option {
+	return func(s *Server) {
+		s.watchInterval = interval
+	}
+}
+
+// Server is a grpc_health_v1.HealthServer backed by a health.Registry.
+type Server struct {
+	healthpb.UnimplementedHealthServer
+
+	reg           *health.Registry
+	watchInterval time.Duration
+}
+
+// NewServer returns a Server that reports the health of reg.
+func NewServer(reg *health.Registry, opts ...Option) *Server {
+	s := &Server{reg: reg, watchInterval: defaultWatchInterval}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Check reports the current status of the registry, or of one of its checks
+// if the request names one, failing with NotFound if it isn't registered.
+func (s *Server) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st := s.status(ctx, req.GetService())
+	if st == healthpb.HealthCheckResponse_SERVICE_UNKNOWN {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the current status of the registry or the named check, then
+// sends it again every time it changes until the client goes away.
+func (s *Server) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx := stream.Context()
+	t := time.NewTicker(s.watchInterval)
+	defer t.Stop()
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		if st := s.status(ctx, req.GetService()); st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// List reports the status of the registry under the empty name and of each
+// check under its own.
+func (s *Server) List(ctx context.Context, req *healthpb.HealthListRequest) (*healthpb.HealthListResponse, error) {
+	report := s.reg.Check(ctx)
+	statuses := map[string]*healthpb.HealthCheckResponse{
+		"": {Status: servingStatus(report.Healthy())},
+	}
+	for _, res := range report.Results {
+		statuses[res.Name] = &healthpb.HealthCheckResponse{Status: servingStatus(res.Healthy())}
+	}
+	return &healthpb.HealthListResponse{Statuses: statuses}, nil
+}
+
+func (s *Server) status(ctx context.Context, service string) healthpb.HealthCheckResponse_ServingStatus {
+	report := s.reg.Check(ctx)
+	if service == "" {
+		return servingStatus(report.Healthy())
+	}
+	for _, res := range report.Results {
+		if res.Name == service {
+			return servingStatus(res.Healthy())
+		}
+	}
+	return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+}
+
+func servingStatus(healthy bool) healthpb.HealthCheckResponse_ServingStatus {
+	if healthy {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
@@ -0,0 +1,135 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is the body a health endpoint responds with.
+type Format int
+
+const (
+	// FormatNone is just a status code, which is all load balancers need.
+	FormatNone Format = iota
+	// FormatJSON is a JSON report, for monitoring.
+	FormatJSON
+	// FormatText is a line per check, for people.
+	FormatText
+)
+
+// Negotiate picks the format to answer a health request in. An Accept header
+// asking for application/json or text/plain gets that, otherwise ?verbose=1
+// gets JSON and anything else just a status code.
+func Negotiate(req *http.Request) Format {
+	accept := req.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/json"):
+		return FormatJSON
+	case strings.Contains(accept, "text/plain"):
+		return FormatText
+	case Verbose(req):
+		return FormatJSON
+	default:
+		return FormatNone
+	}
+}
+
+// Verbose reports whether a health request asked for a detailed response with
+// ?verbose=1.
+func Verbose(req *http.Request) bool {
+	v, _ := strconv.ParseBool(req.URL.Query().Get("verbose"))
+	return v
+}
+
+// Handler returns an http.Handler that runs the checks and responds with 200
+// if they all pass and 503 otherwise. The body is picked by Negotiate: a JSON
+// report or a line per check listing its status, latency and error, so
+// whoever is on call can see which dependency is failing.
+//
+// ?include= and ?exclude= take comma-separated check names, and may be
+// repeated, to only run some of the checks or to skip some, so different
+// probes can depend on different things.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := r.check(req.Context(), filter(req))
+		status := http.StatusOK
+		if !report.Healthy() {
+			status = http.StatusServiceUnavailable
+		}
+		switch Negotiate(req) {
+		case FormatJSON:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(report)
+		case FormatText:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(status)
+			writeText(w, report)
+		default:
+			w.WriteHeader(status)
+		}
+	})
+}
+
+// filter returns the func that picks the checks req asked for with ?include=
+// and ?exclude=, or nil if it asked for all of them.
+func filter(req *http.Request) func(name string) bool {
+	query := req.URL.Query()
+	include := names(query["include"])
+	exclude := names(query["exclude"])
+	if include == nil && exclude == nil {
+		return nil
+	}
+	return func(name string) bool {
+		if include != nil && !include[name] {
+			return false
+		}
+		return !exclude[name]
+	}
+}
+
+// names splits comma-separated lists of names into a set, or returns nil if
+// there are none.
+func names(lists []string) map[string]bool {
+	var set map[string]bool
+	for _, list := range lists {
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if set == nil {
+				set = make(map[string]bool)
+			}
+			set[name] = true
+		}
+	}
+	return set
+}
+
+func writeText(w http.ResponseWriter, report Report) {
+	overall := statusString(report.Healthy())
+	if report.Healthy() && report.Degraded() {
+		overall = "degraded"
+	}
+	if report.NotReady {
+		overall += " (taken out of rotation)"
+	}
+	fmt.Fprintln(w, overall)
+	for _, res := range report.Results {
+		fmt.Fprintf(w, "%s: %s (%s)", res.Name, res.status(), res.Duration.Round(time.Microsecond))
+		if res.Informational {
+			fmt.Fprint(w, " [informational]")
+		}
+		switch {
+		case res.Err != nil:
+			fmt.Fprintf(w, ": %v", res.Err)
+		case res.Pending != nil:
+			fmt.Fprintf(w, ": pending %v", res.Pending)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,452 @@
+// Package health aggregates the health of the dependencies a service needs in
+// order to take traffic, such as databases and queues, into a single readiness
+// result.
+//
+// Components register named Checkers with a Registry, and the registry's
+// Handler serves the aggregate, answering 200 when every check passes and 503
+// otherwise:
+//
+//	reg := health.NewRegistry()
+//	reg.Register("cache", health.CheckerFunc(func(ctx context.Context) error {
+//		return cache.Ping(ctx)
+//	}))
+//	internalMux.Handle("/readiness", reg.Handler())
+//
+// Checks are run each time the handler is asked, unless they are registered
+// with Background, in which case Run runs them on their own interval and the
+// handler serves their latest results:
+//
+//	reg.Register("db", health.SQLChecker(db), health.Background(10*time.Second))
+//	go reg.Run(ctx)
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDeadlineExceeded is the error reported for a check that hadn't finished
+// by the registry's ReportDeadline.
+var ErrDeadlineExceeded = errors.New("health: check didn't finish before the report deadline")
+
+// Checker reports whether a dependency is healthy.
+type Checker interface {
+	// Check returns nil if the dependency is healthy, or an error describing
+	// why it isn't. It should give up when ctx is done.
+	Check(ctx context.Context) error
+}
+
+// DetailedChecker is a Checker that also reports details about the dependency,
+// such as connection pool stats, alongside its result.
+type DetailedChecker interface {
+	Checker
+	// Details is called after each Check.
+	Details() map[string]any
+}
+
+// CheckerFunc adapts a function to a Checker.
+type CheckerFunc func(ctx context.Context) error
+
+// Check calls f(ctx).
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Result is the outcome of running one check.
+type Result struct {
+	Name string
+	Err  error
+	// Time is when the check started running.
+	Time     time.Time
+	Duration time.Duration
+	// Details are reported by checkers that implement DetailedChecker.
+	Details map[string]any
+	// Informational is set for checks registered with Informational, whose
+	// failures are reported but don't make the registry unhealthy.
+	Informational bool
+	// InGracePeriod is set while a check registered with GracePeriod is still
+	// in its grace period, during which its failures don't make the registry
+	// unhealthy either.
+	InGracePeriod bool
+	// Pending is the error from the latest run of a check registered with
+	// FailureThreshold when it failed without reaching the threshold, so the
+	// check still counts as healthy.
+	Pending error
+	// Disabled is set for checks turned off with Disable, which aren't run and
+	// count as healthy.
+	Disabled bool
+}
+
+// Healthy reports whether the check passed.
+func (r Result) Healthy() bool {
+	return r.Err == nil
+}
+
+// Report is the outcome of running every registered check.
+type Report struct {
+	Results []Result
+	// NotReady is set when SetReady(false) has taken the instance out of
+	// rotation, whatever the results.
+	NotReady bool
+}
+
+// Healthy reports whether every check passed and the instance hasn't been
+// taken out of rotation with SetReady.
+func (r Report) Healthy() bool {
+	if r.NotReady {
+		return false
+	}
+	for _, res := range r.Results {
+		if !res.Healthy() && !res.Informational && !res.InGracePeriod {
+			return false
+		}
+	}
+	return true
+}
+
+// Degraded reports whether any informational check, or check in its grace
+// period, failed.
+func (r Report) Degraded() bool {
+	for _, res := range r.Results {
+		if !res.Healthy() && (res.Informational || res.InGracePeriod) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry holds named checks and aggregates their results. The zero value is
+// not usable; create one with NewRegistry.
+type Registry struct {
+	concurrency  int
+	checkTimeout time.Duration
+	deadline     time.Duration
+	historySize  int
+
+	mu     sync.Mutex
+	checks []check
+	// notReady is set by SetReady(false)
+	notReady bool
+	// disabled holds when each check turned off with Disable is turned back
+	// on, or the zero time if it stays off until Enable is called
+	disabled map[string]time.Time
+	// ctx is set while Run is running background checks
+	ctx context.Context
+	wg  sync.WaitGroup
+
+	onChange      []func(healthy bool)
+	onCheckChange []func(res Result)
+
+	// notifyMu keeps observers seeing transitions in order and guards the
+	// last results they were told about
+	notifyMu         sync.Mutex
+	failing          map[string]bool
+	aggregateFailing bool
+
+	stats   checkStats
+	history history
+}
+
+type check struct {
+	name    string
+	checker Checker
+	timeout time.Duration
+	// interval and cache are set for checks run in the background
+	interval      time.Duration
+	cache         *cache
+	informational bool
+	gracePeriod   time.Duration
+	grace         *grace
+	// failureThreshold and successThreshold configure damping, which is set
+	// if either is over 1
+	failureThreshold int
+	successThreshold int
+	damping          *damping
+}
+
+// NewRegistry returns an empty Registry. A registry with no checks is healthy.
+func NewRegistry(opts ...RegistryOption) *Registry {
+	r := &Registry{concurrency: defaultConcurrency, historySize: defaultHistorySize}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Register adds c to the registry under name, replacing any check already
+// registered with that name.
+func (r *Registry) Register(name string, c Checker, opts ...CheckOption) {
+	ch := check{name: name, checker: c, timeout: r.checkTimeout}
+	for _, opt := range opts {
+		opt(&ch)
+	}
+	if ch.interval > 0 {
+		ch.cache = &cache{}
+	}
+	if ch.gracePeriod > 0 {
+		ch.grace = &grace{period: ch.gracePeriod}
+	}
+	if ch.failureThreshold > 1 || ch.successThreshold > 1 {
+		ch.damping = &damping{}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ch.cache != nil && r.ctx != nil {
+		r.startLocked(ch)
+	}
+	for i := range r.checks {
+		if r.checks[i].name == name {
+			r.checks[i].stop()
+			r.checks[i] = ch
+			return
+		}
+	}
+	r.checks = append(r.checks, ch)
+}
+
+// Unregister removes the check registered under name, if any.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	for i := range r.checks {
+		if r.checks[i].name == name {
+			r.checks[i].stop()
+			r.checks = append(r.checks[:i], r.checks[i+1:]...)
+			break
+		}
+	}
+	delete(r.disabled, name)
+	r.mu.Unlock()
+	r.forget(name)
+	r.forgetMetrics(name)
+	r.forgetHistory(name)
+}
+
+// SetReady(false) takes the instance out of rotation without shutting it down,
+// making the registry unhealthy whatever its checks report, until
+// SetReady(true) puts it back.
+func (r *Registry) SetReady(ready bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notReady = !ready
+}
+
+// Ready reports whether the instance is in rotation as far as SetReady is
+// concerned. It doesn't run any checks.
+func (r *Registry) Ready() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.notReady
+}
+
+// Check runs every registered check, several at a time as limited by
+// MaxConcurrency, and reports their results in the order the checks were
+// registered. Checks registered with Background aren't run; their latest
+// result is reported instead.
+func (r *Registry) Check(ctx context.Context) Report {
+	return r.check(ctx, nil)
+}
+
+// check runs the checks keep returns true for, or all of them if keep is nil.
+// Only full reports are used to tell whether the registry as a whole flipped.
+func (r *Registry) check(ctx context.Context, keep func(name string) bool) Report {
+	r.mu.Lock()
+	checks := append([]check(nil), r.checks...)
+	notReady := r.notReady
+	disabled := r.disabledLocked()
+	r.mu.Unlock()
+
+	report := Report{Results: make([]Result, 0, len(checks)), NotReady: notReady}
+	var pending []pendingCheck
+	for _, c := range checks {
+		if keep != nil && !keep(c.name) {
+			continue
+		}
+		if _, ok := disabled[c.name]; ok {
+			report.Results = append(report.Results, Result{Name: c.name, Informational: c.informational, Disabled: true})
+			continue
+		}
+		if c.cache != nil {
+			report.Results = append(report.Results, c.cache.result(c))
+			continue
+		}
+		pending = append(pending, pendingCheck{i: len(report.Results), check: c})
+		report.Results = append(report.Results, Result{})
+	}
+	r.runAll(ctx, report.Results, pending)
+
+	if keep == nil {
+		r.observeReport(report)
+	}
+	return report
+}
+
+// pendingCheck is a check to run whose result goes in results[i].
+type pendingCheck struct {
+	i int
+	check
+}
+
+// runAll runs the pending checks, at most r.concurrency at a time, and fills
+// in their results. Checks that haven't finished by the report deadline, or
+// when ctx is done, are reported as failing with ErrDeadlineExceeded and left
+// to finish in the background.
+func (r *Registry) runAll(ctx context.Context, results []Result, pending []pendingCheck) {
+	if len(pending) == 0 {
+		return
+	}
+	if r.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.deadline)
+		defer cancel()
+	}
+
+	var (
+		mu       sync.Mutex
+		finished bool
+		done     = make([]bool, len(results))
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, max(r.concurrency, 1))
+	)
+	for _, p := range pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			res := p.run(ctx)
+			r.record(res)
+			res = p.damp(res)
+			r.observe(res)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !finished {
+				results[p.i] = res
+				done[p.i] = true
+			}
+		}()
+	}
+
+	all := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(all)
+	}()
+	select {
+	case <-all:
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	finished = true
+	for _, p := range pending {
+		if !done[p.i] {
+			results[p.i] = Result{
+				Name:          p.name,
+				Err:           ErrDeadlineExceeded,
+				Informational: p.informational,
+				InGracePeriod: p.grace.active(),
+			}
+		}
+	}
+}
+
+// run runs the check once, with its timeout if it has one, and reports its
+// result.
+func (c check) run(ctx context.Context) Result {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err := c.checker.Check(ctx)
+	res := Result{
+		Name:          c.name,
+		Err:           err,
+		Time:          start,
+		Duration:      time.Since(start),
+		Informational: c.informational,
+		InGracePeriod: c.grace.active(),
+	}
+	if dc, ok := c.checker.(DetailedChecker); ok {
+		res.Details = dc.Details()
+	}
+	return res
+}
+
+// MarshalJSON encodes the report with an overall status and one entry per
+// check.
+func (r Report) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Status   string   `json:"status"`
+		NotReady bool     `json:"not_ready,omitempty"`
+		Checks   []Result `json:"checks"`
+	}{
+		Status:   statusString(r.Healthy()),
+		NotReady: r.NotReady,
+		Checks:   r.Results,
+	}
+	if out.Status == "ok" && r.Degraded() {
+		out.Status = "degraded"
+	}
+	if out.Checks == nil {
+		out.Checks = []Result{}
+	}
+	return json.Marshal(out)
+}
+
+// MarshalJSON encodes the result with its status, latency and error.
+func (r Result) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Name          string         `json:"name"`
+		Status        string         `json:"status"`
+		Time          time.Time      `json:"time,omitzero"`
+		Informational bool           `json:"informational,omitempty"`
+		InGracePeriod bool           `json:"grace_period,omitempty"`
+		LatencyMS     float64        `json:"latency_ms"`
+		Error         string         `json:"error,omitempty"`
+		Pending       string         `json:"pending,omitempty"`
+		Details       map[string]any `json:"details,omitempty"`
+	}{
+		Name:          r.Name,
+		Status:        r.status(),
+		Time:          r.Time,
+		Informational: r.Informational,
+		InGracePeriod: r.InGracePeriod,
+		LatencyMS:     float64(r.Duration.Microseconds()) / 1000,
+		Details:       r.Details,
+	}
+	if r.Err != nil {
+		out.Error = r.Err.Error()
+	}
+	if r.Pending != nil {
+		out.Pending = r.Pending.Error()
+	}
+	return json.Marshal(out)
+}
+
+// status is how the result is described in health responses.
+func (r Result) status() string {
+	if r.Disabled {
+		return "disabled"
+	}
+	return statusString(r.Healthy())
+}
+
+func statusString(healthy bool) string {
+	if healthy {
+		return "ok"
+	}
+	return "fail"
+}
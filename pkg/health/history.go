@@ -0,0 +1,72 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// history holds the latest results of each check that has run, oldest first.
+type history struct {
+	mu      sync.Mutex
+	results map[string][]Result
+}
+
+// remember adds res to the history of its check, dropping the oldest result
+// once there are more than r.historySize.
+func (r *Registry) remember(res Result) {
+	if r.historySize <= 0 {
+		return
+	}
+	h := &r.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.results == nil {
+		h.results = make(map[string][]Result)
+	}
+	results := h.results[res.Name]
+	if len(results) == r.historySize {
+		copy(results, results[1:])
+		results = results[:len(results)-1]
+	}
+	h.results[res.Name] = append(results, res)
+}
+
+// History returns the latest results of the check registered under name,
+// oldest first. How many are kept is set with HistorySize.
+func (r *Registry) History(name string) []Result {
+	h := &r.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Result(nil), h.results[name]...)
+}
+
+// forgetHistory drops the history of the check called name.
+func (r *Registry) forgetHistory(name string) {
+	h := &r.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.results, name)
+}
+
+// HistoryHandler returns an http.Handler that serves the latest results of
+// every check as JSON, keyed by check name, so when a probe flaps in the
+// middle of the night there's a record of when and why each check failed.
+// ?check= limits it to one check.
+func (r *Registry) HistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		out := make(map[string][]Result)
+		if name := req.URL.Query().Get("check"); name != "" {
+			out[name] = r.History(name)
+		} else {
+			h := &r.history
+			h.mu.Lock()
+			for name, results := range h.results {
+				out[name] = append([]Result(nil), results...)
+			}
+			h.mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	})
+}
@@ -0,0 +1,60 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// HTTPChecker returns a Checker that sends a GET request to url and fails if
+// the request fails, takes longer than the timeout, or the response status is
+// not one of the expected ones, which are any 2xx unless WithExpectedStatus
+// says otherwise.
+func HTTPChecker(url string, opts ...Option) Checker {
+	o := newOptions(opts)
+	client := o.httpClient
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if o.tlsConfig != nil {
+			transport.TLSClientConfig = o.tlsConfig
+		}
+		client = &http.Client{Transport: transport}
+	}
+	return &httpChecker{url: url, client: client, opts: o}
+}
+
+type httpChecker struct {
+	url    string
+	client *http.Client
+	opts   options
+}
+
+func (c *httpChecker) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused for the next check
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if len(c.opts.expectedStatus) > 0 {
+		if !slices.Contains(c.opts.expectedStatus, resp.StatusCode) {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
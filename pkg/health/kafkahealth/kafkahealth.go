@@ -0,0 +1,24 @@
+// Package kafkahealth provides a health.Checker for Kafka brokers, using the
+// franz-go client the application already produces or consumes with:
+//
+//	d.Health().Register("kafka", kafkahealth.Checker(client, 2*time.Second))
+package kafkahealth
+
+import (
+	"context"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/forgeutah/utah-go/pkg/health"
+)
+
+// Checker returns a Checker that fails unless one of client's brokers answers
+// an ApiVersions request within timeout.
+func Checker(client *kgo.Client, timeout time.Duration) health.Checker {
+	return health.CheckerFunc(func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return client.Ping(ctx)
+	})
+}
@@ -0,0 +1,89 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+)
+
+// MemoryChecker returns a Checker that fails once the process's memory reaches
+// maxFraction of the limit. Failing readiness at, say, 0.9 lets a node under
+// memory pressure stop taking traffic before the garbage collector thrashes or
+// the process is OOM killed.
+//
+// The memory counted is the larger of what the Go runtime holds from the
+// operating system, which is what GOMEMLIMIT is measured against, and, on
+// Linux, the resident set size from /proc/self/statm, which also takes in
+// memory allocated by C libraries through cgo. Elsewhere only the runtime's
+// memory is counted.
+//
+// The limit is the one set with WithMemoryLimit, or else GOMEMLIMIT or the one
+// set with debug.SetMemoryLimit, or else, on Linux, the memory limit of the
+// cgroup the process runs in, as a container does. With no limit the check
+// always passes and only reports usage as details.
+func MemoryChecker(maxFraction float64, opts ...Option) Checker {
+	return &memoryChecker{maxFraction: maxFraction, opts: newOptions(opts)}
+}
+
+type memoryChecker struct {
+	maxFraction float64
+	opts        options
+}
+
+func (c *memoryChecker) Check(ctx context.Context) error {
+	used, limit := c.usage()
+	if limit <= 0 {
+		return nil
+	}
+	if float64(used) >= c.maxFraction*float64(limit) {
+		return fmt.Errorf("using %d of %d bytes of memory", used, limit)
+	}
+	return nil
+}
+
+func (c *memoryChecker) Details() map[string]any {
+	used, limit := c.usage()
+	details := map[string]any{"used_bytes": used, "runtime_bytes": runtimeMemory()}
+	if rss, ok := residentMemory(); ok {
+		details["rss_bytes"] = rss
+	}
+	if limit > 0 {
+		details["limit_bytes"] = limit
+	}
+	return details
+}
+
+// usage returns the memory the process is using and the limit to compare it
+// against, or 0 if there isn't one.
+func (c *memoryChecker) usage() (used uint64, limit int64) {
+	used = runtimeMemory()
+	if rss, ok := residentMemory(); ok {
+		used = max(used, rss)
+	}
+
+	limit = c.opts.memoryLimit
+	if limit == 0 {
+		// a negative input reads the limit without changing it
+		limit = debug.SetMemoryLimit(-1)
+		if limit == math.MaxInt64 {
+			limit = 0
+		}
+	}
+	if limit == 0 {
+		limit, _ = cgroupMemoryLimit()
+	}
+	return used, limit
+}
+
+// runtimeMemory returns the memory the runtime has mapped and not yet
+// released.
+func runtimeMemory() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
@@ -0,0 +1,48 @@
+package health
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+)
+
+// residentMemory returns the process's resident set size, the second field of
+// /proc/self/statm counted in pages.
+func residentMemory() (uint64, bool) {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	fields := bytes.Fields(statm)
+	if len(fields) < 2 {
+		return 0, false
+	}
+	pages, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return pages * uint64(os.Getpagesize()), true
+}
+
+// cgroupMemoryLimit returns the memory limit of the cgroup the process runs in,
+// as a container does, from cgroup v2's memory.max or else cgroup v1's
+// memory.limit_in_bytes.
+func cgroupMemoryLimit() (int64, bool) {
+	for _, path := range []string{
+		"/sys/fs/cgroup/memory.max",
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+	} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// v2 says max when there's no limit, and v1 gives a number close to
+		// the largest int64, rounded down to a page
+		limit, err := strconv.ParseInt(string(bytes.TrimSpace(b)), 10, 64)
+		if err != nil || limit <= 0 || limit >= 1<<62 {
+			return 0, false
+		}
+		return limit, true
+	}
+	return 0, false
+}
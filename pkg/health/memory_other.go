@@ -0,0 +1,13 @@
+//go:build !linux
+
+package health
+
+// residentMemory is only read on Linux. Elsewhere getrusage only gives the
+// peak, which never comes back down once a spike has passed.
+func residentMemory() (uint64, bool) {
+	return 0, false
+}
+
+func cgroupMemoryLimit() (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,145 @@
+package health
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/metrics"
+)
+
+// DurationBuckets are the upper bounds of the buckets check durations are
+// counted in.
+var DurationBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// CheckMetrics counts how often a check has run, how it went and how long it
+// took, so dashboards can show dependency health over time rather than just the
+// latest result.
+type CheckMetrics struct {
+	Name      string
+	Successes uint64
+	Failures  uint64
+	// Buckets[i] is the number of runs that took at most DurationBuckets[i].
+	// The counts are cumulative, as in a Prometheus histogram.
+	Buckets []uint64
+	// DurationSum is the total time spent running the check.
+	DurationSum time.Duration
+}
+
+// Count returns the number of times the check has run.
+func (m CheckMetrics) Count() uint64 {
+	return m.Successes + m.Failures
+}
+
+// checkStats holds the CheckMetrics of each check that has run.
+type checkStats struct {
+	mu     sync.Mutex
+	checks map[string]*CheckMetrics
+}
+
+// record counts a run of a check that ended with res and adds it to the
+// check's history.
+func (r *Registry) record(res Result) {
+	r.remember(res)
+
+	m := &r.stats
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cm, ok := m.checks[res.Name]
+	if !ok {
+		if m.checks == nil {
+			m.checks = make(map[string]*CheckMetrics)
+		}
+		cm = &CheckMetrics{Name: res.Name, Buckets: make([]uint64, len(DurationBuckets))}
+		m.checks[res.Name] = cm
+	}
+	if res.Healthy() {
+		cm.Successes++
+	} else {
+		cm.Failures++
+	}
+	cm.DurationSum += res.Duration
+	for i, bound := range DurationBuckets {
+		if res.Duration <= bound {
+			cm.Buckets[i]++
+		}
+	}
+}
+
+// Metrics returns the metrics of every check that has run, sorted by name.
+func (r *Registry) Metrics() []CheckMetrics {
+	m := &r.stats
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]CheckMetrics, 0, len(m.checks))
+	for _, cm := range m.checks {
+		c := *cm
+		c.Buckets = slices.Clone(cm.Buckets)
+		out = append(out, c)
+	}
+	slices.SortFunc(out, func(a, b CheckMetrics) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return out
+}
+
+// forgetMetrics drops the metrics of the check called name.
+func (r *Registry) forgetMetrics(name string) {
+	m := &r.stats
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.checks, name)
+}
+
+// MetricsHandler returns an http.Handler that serves the metrics of every
+// check in the Prometheus text format, as health_check_total counters labeled
+// by check and result and a health_check_duration_seconds histogram per check.
+func (r *Registry) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes the metrics MetricsHandler serves to w, which makes the
+// registry a metrics.Collector.
+func (r *Registry) WriteMetrics(w io.Writer) {
+	all := r.Metrics()
+
+	fmt.Fprintln(w, "# HELP health_check_total Health check runs by result.")
+	fmt.Fprintln(w, "# TYPE health_check_total counter")
+	for _, m := range all {
+		check := metrics.QuoteLabel(m.Name)
+		fmt.Fprintf(w, "health_check_total{check=%s,result=\"success\"} %d\n", check, m.Successes)
+		fmt.Fprintf(w, "health_check_total{check=%s,result=\"failure\"} %d\n", check, m.Failures)
+	}
+
+	fmt.Fprintln(w, "# HELP health_check_duration_seconds How long health checks take to run.")
+	fmt.Fprintln(w, "# TYPE health_check_duration_seconds histogram")
+	for _, m := range all {
+		check := metrics.QuoteLabel(m.Name)
+		for i, bound := range DurationBuckets {
+			fmt.Fprintf(w, "health_check_duration_seconds_bucket{check=%s,le=\"%g\"} %d\n", check, bound.Seconds(), m.Buckets[i])
+		}
+		fmt.Fprintf(w, "health_check_duration_seconds_bucket{check=%s,le=\"+Inf\"} %d\n", check, m.Count())
+		fmt.Fprintf(w, "health_check_duration_seconds_sum{check=%s} %g\n", check, m.DurationSum.Seconds())
+		fmt.Fprintf(w, "health_check_duration_seconds_count{check=%s} %d\n", check, m.Count())
+	}
+}
@@ -0,0 +1,47 @@
+// Package natshealth provides a health.Checker for NATS servers, using the
+// connection the application already has:
+//
+//	d.Health().Register("nats", natshealth.Checker(nc, 2*time.Second))
+package natshealth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/forgeutah/utah-go/pkg/health"
+)
+
+// Checker returns a Checker that fails unless nc is connected and the server
+// answers a ping within timeout. It reports the connection's status, server
+// and round trip time as details.
+func Checker(nc *nats.Conn, timeout time.Duration) health.Checker {
+	return &checker{nc: nc, timeout: timeout}
+}
+
+type checker struct {
+	nc      *nats.Conn
+	timeout time.Duration
+}
+
+func (c *checker) Check(ctx context.Context) error {
+	if status := c.nc.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("connection is %s", status)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.nc.FlushWithContext(ctx)
+}
+
+func (c *checker) Details() map[string]any {
+	details := map[string]any{
+		"status": c.nc.Status().String(),
+		"server": c.nc.ConnectedUrlRedacted(),
+	}
+	if rtt, err := c.nc.RTT(); err == nil {
+		details["rtt_ms"] = float64(rtt.Microseconds()) / 1000
+	}
+	return details
+}
@@ -0,0 +1,171 @@
+package health
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultTimeout     = time.Second
+	defaultConcurrency = 8
+	defaultHistorySize = 32
+)
+
+// Option configures one of the built-in checkers. Each option documents which
+// checkers it applies to; the others ignore it.
+type Option func(*options)
+
+type options struct {
+	timeout        time.Duration
+	expectedStatus []int
+	tlsConfig      *tls.Config
+	httpClient     *http.Client
+	banner         string
+	memoryLimit    int64
+}
+
+func newOptions(opts []Option) options {
+	o := options{timeout: defaultTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithTimeout limits how long a single check may take. It applies to
+// SQLChecker, HTTPChecker and TCPChecker and defaults to 1 second.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithExpectedStatus sets the response statuses HTTPChecker treats as healthy.
+// By default any 2xx status is.
+func WithExpectedStatus(codes ...int) Option {
+	return func(o *options) {
+		o.expectedStatus = codes
+	}
+}
+
+// WithTLSConfig sets the TLS configuration HTTPChecker uses to connect, e.g.
+// to trust a private CA or present a client certificate.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = cfg
+	}
+}
+
+// WithHTTPClient sets the client HTTPChecker sends requests with, in which case
+// WithTLSConfig is ignored.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithBanner makes TCPChecker read the first line the server sends and fail
+// unless it starts with prefix, e.g. "220 " for an SMTP relay.
+func WithBanner(prefix string) Option {
+	return func(o *options) {
+		o.banner = prefix
+	}
+}
+
+// WithMemoryLimit sets the limit in bytes MemoryChecker compares usage against,
+// for processes that don't set GOMEMLIMIT and don't run in a cgroup with a
+// memory limit, or that should stop taking traffic well before either.
+func WithMemoryLimit(bytes int64) Option {
+	return func(o *options) {
+		o.memoryLimit = bytes
+	}
+}
+
+// CheckOption configures how a registry runs a check.
+type CheckOption func(*check)
+
+// Background makes the registry run the check on its own every interval while
+// Run is running, and report the latest result instead of running the check
+// when asked. Probes that arrive every few seconds then don't each hit the
+// dependency, and a slow dependency doesn't slow the probe down.
+func Background(interval time.Duration) CheckOption {
+	return func(c *check) {
+		c.interval = interval
+	}
+}
+
+// Informational marks the check as informational: its result is reported, but
+// a failure only makes the registry degraded rather than unhealthy, so e.g. a
+// struggling cache doesn't take the whole instance out of rotation. Checks are
+// critical by default.
+func Informational() CheckOption {
+	return func(c *check) {
+		c.informational = true
+	}
+}
+
+// GracePeriod gives the check a grace period, starting when it is first run,
+// during which its failures are reported but don't make the registry
+// unhealthy. That keeps an instance from flapping in and out of rotation while
+// it cold starts and its pools and caches are still connecting.
+func GracePeriod(period time.Duration) CheckOption {
+	return func(c *check) {
+		c.gracePeriod = period
+	}
+}
+
+// FailureThreshold makes the check only count as failing once it has failed n
+// times in a row, so a single transient blip, such as a DNS timeout, doesn't
+// bounce the instance out of rotation. Until then its result is healthy, with
+// the error reported as Pending.
+func FailureThreshold(n int) CheckOption {
+	return func(c *check) {
+		c.failureThreshold = n
+	}
+}
+
+// SuccessThreshold makes a failing check only count as healthy again once it
+// has passed n times in a row. Until then its result keeps the last error.
+func SuccessThreshold(n int) CheckOption {
+	return func(c *check) {
+		c.successThreshold = n
+	}
+}
+
+// RegistryOption configures a Registry.
+type RegistryOption func(*Registry)
+
+// MaxConcurrency limits how many checks a registry runs at once when it is
+// checked. It defaults to 8; 1 runs them one after the other.
+func MaxConcurrency(n int) RegistryOption {
+	return func(r *Registry) {
+		r.concurrency = n
+	}
+}
+
+// CheckTimeout gives every check registered afterwards a timeout of its own,
+// on top of any the checker applies itself.
+func CheckTimeout(timeout time.Duration) RegistryOption {
+	return func(r *Registry) {
+		r.checkTimeout = timeout
+	}
+}
+
+// ReportDeadline limits how long checking the registry may take as a whole,
+// so a single slow dependency can't make the probe itself time out. Checks
+// that haven't finished by then are reported as failing with
+// ErrDeadlineExceeded.
+func ReportDeadline(deadline time.Duration) RegistryOption {
+	return func(r *Registry) {
+		r.deadline = deadline
+	}
+}
+
+// HistorySize sets how many of the latest results of each check the registry
+// keeps for History. It defaults to 32; 0 keeps none.
+func HistorySize(n int) RegistryOption {
+	return func(r *Registry) {
+		r.historySize = n
+	}
+}
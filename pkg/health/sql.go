@@ -0,0 +1,36 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SQLChecker returns a Checker that pings db, failing if the ping fails or
+// takes longer than the timeout. It also reports the connection pool's stats
+// as details.
+func SQLChecker(db *sql.DB, opts ...Option) Checker {
+	return &sqlChecker{db: db, opts: newOptions(opts)}
+}
+
+type sqlChecker struct {
+	db   *sql.DB
+	opts options
+}
+
+func (c *sqlChecker) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.timeout)
+	defer cancel()
+	return c.db.PingContext(ctx)
+}
+
+func (c *sqlChecker) Details() map[string]any {
+	stats := c.db.Stats()
+	return map[string]any{
+		"max_open_connections": stats.MaxOpenConnections,
+		"open_connections":     stats.OpenConnections,
+		"in_use":               stats.InUse,
+		"idle":                 stats.Idle,
+		"wait_count":           stats.WaitCount,
+		"wait_duration_ms":     stats.WaitDuration.Milliseconds(),
+	}
+}
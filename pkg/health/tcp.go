@@ -0,0 +1,48 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// TCPChecker returns a Checker that dials addr over TCP, failing if the
+// connection can't be made within the timeout. With WithBanner it also reads
+// the first line the server sends and fails if it doesn't start as expected.
+func TCPChecker(addr string, opts ...Option) Checker {
+	return &tcpChecker{addr: addr, opts: newOptions(opts)}
+}
+
+type tcpChecker struct {
+	addr string
+	opts options
+}
+
+func (c *tcpChecker) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if c.opts.banner == "" {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading banner: %w", err)
+	}
+	if !strings.HasPrefix(line, c.opts.banner) {
+		return fmt.Errorf("unexpected banner %q", strings.TrimRight(line, "\r\n"))
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package health
+
+import "errors"
+
+// OnChange registers fn to be called whenever the registry flips between
+// healthy and unhealthy, e.g. to page someone or deregister from service
+// discovery. The registry starts out healthy, and flips are noticed when it is
+// checked, typically by a readiness probe. Callbacks are called one at a time,
+// in the order the transitions happen, and must not block for long since the
+// check that noticed the flip waits for them.
+func (r *Registry) OnChange(fn func(healthy bool)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onChange = append(r.onChange, fn)
+}
+
+// OnCheckChange registers fn to be called with a check's result whenever the
+// check starts failing or recovers. Checks start out healthy. Background checks
+// are watched as they run; other checks when the registry is checked. Callbacks
+// are called the same way as OnChange's.
+func (r *Registry) OnCheckChange(fn func(res Result)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onCheckChange = append(r.onCheckChange, fn)
+}
+
+// observe notifies the OnCheckChange observers if res differs from the last
+// result for its check.
+func (r *Registry) observe(res Result) {
+	// a background check that hasn't run yet hasn't told us anything
+	if errors.Is(res.Err, ErrNotChecked) {
+		return
+	}
+
+	r.notifyMu.Lock()
+	defer r.notifyMu.Unlock()
+	failing := !res.Healthy()
+	if r.failing[res.Name] == failing {
+		return
+	}
+	if r.failing == nil {
+		r.failing = make(map[string]bool)
+	}
+	r.failing[res.Name] = failing
+
+	r.mu.Lock()
+	observers := append([]func(Result){}, r.onCheckChange...)
+	r.mu.Unlock()
+	for _, fn := range observers {
+		fn(res)
+	}
+}
+
+// observeReport notifies the OnChange observers if report's health differs
+// from the last report's.
+func (r *Registry) observeReport(report Report) {
+	r.notifyMu.Lock()
+	defer r.notifyMu.Unlock()
+	healthy := report.Healthy()
+	if r.aggregateFailing == !healthy {
+		return
+	}
+	r.aggregateFailing = !healthy
+
+	r.mu.Lock()
+	observers := append([]func(bool){}, r.onChange...)
+	r.mu.Unlock()
+	for _, fn := range observers {
+		fn(healthy)
+	}
+}
+
+// forget drops what the observers were last told about the check called name,
+// so a check registered under that name later starts out healthy.
+func (r *Registry) forget(name string) {
+	r.notifyMu.Lock()
+	defer r.notifyMu.Unlock()
+	delete(r.failing, name)
+}
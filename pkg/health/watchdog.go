@@ -0,0 +1,101 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watchdog is a Checker that fails when a component stops sending heartbeats,
+// e.g. because its event loop or a lock it needs is wedged. Registered with
+// the daemon's liveness checks, it gets a process that is stuck restarted
+// rather than left up and useless. The zero value is not usable; create one
+// with NewWatchdog.
+type Watchdog struct {
+	mu    sync.Mutex
+	beats map[*Heartbeat]struct{}
+}
+
+// NewWatchdog returns a Watchdog with no heartbeats, which always passes.
+func NewWatchdog() *Watchdog {
+	return &Watchdog{beats: make(map[*Heartbeat]struct{})}
+}
+
+// Heartbeat registers a component called name, which must call Beat on the
+// returned Heartbeat at least every timeout for the watchdog to pass. The
+// clock starts when Heartbeat is called.
+func (w *Watchdog) Heartbeat(name string, timeout time.Duration) *Heartbeat {
+	hb := &Heartbeat{w: w, name: name, timeout: timeout, last: time.Now()}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.beats[hb] = struct{}{}
+	return hb
+}
+
+// Check fails if any component's heartbeat has gone stale.
+func (w *Watchdog) Check(ctx context.Context) error {
+	var stale []string
+	for _, hb := range w.heartbeats() {
+		if age := hb.age(); age > hb.timeout {
+			stale = append(stale, fmt.Sprintf("%s (last heartbeat %s ago)", hb.name, age.Round(time.Millisecond)))
+		}
+	}
+	if len(stale) > 0 {
+		slices.Sort(stale)
+		return fmt.Errorf("stale heartbeats: %s", strings.Join(stale, ", "))
+	}
+	return nil
+}
+
+// Details reports how long ago each component last sent a heartbeat.
+func (w *Watchdog) Details() map[string]any {
+	details := make(map[string]any)
+	for _, hb := range w.heartbeats() {
+		details[hb.name+"_age_ms"] = hb.age().Milliseconds()
+	}
+	return details
+}
+
+func (w *Watchdog) heartbeats() []*Heartbeat {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	beats := make([]*Heartbeat, 0, len(w.beats))
+	for hb := range w.beats {
+		beats = append(beats, hb)
+	}
+	return beats
+}
+
+// Heartbeat is a component registered with a Watchdog.
+type Heartbeat struct {
+	w       *Watchdog
+	name    string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// Beat tells the watchdog the component is still making progress.
+func (hb *Heartbeat) Beat() {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	hb.last = time.Now()
+}
+
+// Stop unregisters the component, e.g. once it has shut down, so the watchdog
+// no longer expects heartbeats from it.
+func (hb *Heartbeat) Stop() {
+	hb.w.mu.Lock()
+	defer hb.w.mu.Unlock()
+	delete(hb.w.beats, hb)
+}
+
+func (hb *Heartbeat) age() time.Duration {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	return time.Since(hb.last)
+}
@@ -0,0 +1,190 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// hedgeWindow is how many of the latest latencies the hedging delay is
+	// worked out from.
+	hedgeWindow = 256
+	// hedgeMinSamples is how many latencies a Hedger needs before it trusts
+	// their percentile over its fallback delay.
+	hedgeMinSamples = 20
+)
+
+// Hedger decides when to send a backup for a call that is taking longer than
+// most: once it has taken longer than a percentile of the latencies of recent
+// calls, such as the 95th. Whichever of the two calls succeeds first is used,
+// and the other is canceled through its context, which cuts the tail latency
+// of a dependency with occasional slow calls for the price of a few extra
+// calls. Only hedge calls that are safe to make twice, such as reads.
+//
+// A Hedger is safe for concurrent use, and should be shared by the calls to
+// one dependency so it learns that dependency's latency.
+type Hedger struct {
+	percentile float64
+	fallback   time.Duration
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+	delay     time.Duration
+	stale     bool
+}
+
+// NewHedger returns a Hedger that sends a backup once a call has taken longer
+// than the given percentile, between 0 and 100, of recent latencies, or
+// fallback until it has seen enough calls to tell.
+func NewHedger(percentile float64, fallback time.Duration) *Hedger {
+	return &Hedger{percentile: min(max(percentile, 0), 100), fallback: fallback}
+}
+
+// Delay returns how long a call currently runs before a backup is sent.
+func (h *Hedger) Delay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < hedgeMinSamples {
+		return h.fallback
+	}
+	if h.stale {
+		sorted := slices.Clone(h.latencies)
+		slices.Sort(sorted)
+		h.delay = sorted[int(float64(len(sorted)-1)*h.percentile/100)]
+		h.stale = false
+	}
+	return h.delay
+}
+
+// observe records the latency of a call that succeeded.
+func (h *Hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < hedgeWindow {
+		h.latencies = append(h.latencies, d)
+	} else {
+		h.latencies[h.next] = d
+		h.next = (h.next + 1) % hedgeWindow
+	}
+	h.stale = true
+}
+
+// Hedge calls fn, and calls it again with a context of its own if the first
+// call hasn't returned by h's delay, returning the result of whichever call
+// succeeds first and canceling the other. If the first call fails before the
+// backup has been sent, or both fail, the error of the last to fail is
+// returned. fn must be done with its context once it returns, and results that
+// lose the race are closed if they are io.Closers.
+func Hedge[T any](ctx context.Context, h *Hedger, fn func(ctx context.Context) (T, error)) (T, error) {
+	v, cancel, err := hedge(ctx, h, fn, func(v T) {
+		if c, ok := any(v).(io.Closer); ok {
+			c.Close()
+		}
+	})
+	cancel()
+	return v, err
+}
+
+type hedgeResult[T any] struct {
+	v   T
+	err error
+	// call is the index of the call the result is from
+	call int
+}
+
+// hedge is Hedge with discard disposing of the results that lose the race. The
+// winning call's context is left for the caller to cancel, once it is done
+// with the result, with the returned func.
+func hedge[T any](ctx context.Context, h *Hedger, fn func(ctx context.Context) (T, error), discard func(T)) (T, context.CancelFunc, error) {
+	results := make(chan hedgeResult[T], 2)
+	var cancels []context.CancelFunc
+	start := func() {
+		call := len(cancels)
+		callCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			began := time.Now()
+			v, err := fn(callCtx)
+			if err == nil && callCtx.Err() == nil {
+				h.observe(time.Since(began))
+			}
+			results <- hedgeResult[T]{v: v, err: err, call: call}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(h.Delay())
+	defer timer.Stop()
+	for returned := 0; returned < len(cancels); {
+		var r hedgeResult[T]
+		select {
+		case <-timer.C:
+			start()
+			continue
+		case r = <-results:
+			returned++
+		}
+		if r.err != nil {
+			cancels[r.call]()
+			// without a backup running, a failure is final, as hedging isn't
+			// retrying
+			if returned < len(cancels) {
+				continue
+			}
+			return r.v, func() {}, r.err
+		}
+
+		// the winner: cancel the other call, and clean up after it
+		winner := cancels[r.call]
+		cancels[r.call] = func() {}
+		for _, cancel := range cancels {
+			cancel()
+		}
+		if returned < len(cancels) {
+			go func() {
+				if lost := <-results; lost.err == nil {
+					discard(lost.v)
+				}
+			}()
+		}
+		return r.v, winner, nil
+	}
+	panic("unreachable")
+}
+
+// WithHedging sends a backup of GET and HEAD requests without a body that are
+// taking longer than h allows, using whichever response arrives first. Each
+// attempt made by a client that retries is hedged on its own.
+func WithHedging(h *Hedger) Option {
+	return func(t *transport) {
+		t.hedger = h
+	}
+}
+
+// hedgedTransport sends requests with rt, hedging them with h.
+type hedgedTransport struct {
+	rt http.RoundTripper
+	h  *Hedger
+}
+
+func (t *hedgedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || r.Body != nil && r.Body != http.NoBody {
+		return t.rt.RoundTrip(r)
+	}
+	resp, cancel, err := hedge(r.Context(), t.h, func(ctx context.Context) (*http.Response, error) {
+		return t.rt.RoundTrip(r.WithContext(ctx))
+	}, func(resp *http.Response) {
+		resp.Body.Close()
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
@@ -0,0 +1,257 @@
+// Package httpclient provides HTTP clients for calling other services from a
+// request handler. They retry requests that are safe to retry when they fail in
+// ways that are worth retrying, waiting a jittered, growing backoff in between,
+// and give up before the request's context does, so a handler calling another
+// service with its request context still has time to answer before the route
+// timeout:
+//
+//	client := httpclient.New()
+//
+//	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+//		req, _ := http.NewRequestWithContext(r.Context(), "GET", inventoryURL, nil)
+//		resp, err := client.Do(req)
+//		...
+//	})
+//
+// Requests also carry the trace of their context, as httpmw.TraceTransport
+// passes it on, and the time left until the client gives up on them, as
+// httpmw.DeadlineTransport does, so the service called doesn't carry on after
+// that.
+package httpclient
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultMinBackoff  = 100 * time.Millisecond
+	defaultMaxBackoff  = 2 * time.Second
+	defaultHeadroom    = 100 * time.Millisecond
+)
+
+// Option configures a client made by New.
+type Option func(*transport)
+
+// WithTransport sends requests with rt rather than a clone of
+// http.DefaultTransport.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(t *transport) {
+		t.base = rt
+	}
+}
+
+// WithMaxAttempts sets how many times a request is tried in all, 3 by default.
+// 1 turns retries off.
+func WithMaxAttempts(n int) Option {
+	return func(t *transport) {
+		t.maxAttempts = max(n, 1)
+	}
+}
+
+// WithBackoff sets the backoff between attempts, which starts at minBackoff
+// and doubles after each attempt up to maxBackoff, with the wait picked at
+// random between zero and the backoff so clients that failed together don't
+// retry together. It defaults to between 100 milliseconds and 2 seconds.
+func WithBackoff(minBackoff, maxBackoff time.Duration) Option {
+	return func(t *transport) {
+		t.minBackoff = minBackoff
+		t.maxBackoff = maxBackoff
+	}
+}
+
+// WithTimeout caps the time a request can take, across all of its attempts
+// and the backoff between them, including reading the response body.
+func WithTimeout(timeout time.Duration) Option {
+	return func(t *transport) {
+		t.timeout = timeout
+	}
+}
+
+// WithHeadroom sets how long before the deadline of a request's context the
+// client gives up on it, 100 milliseconds by default, which leaves the handler
+// that made the request time to answer its own client, e.g. with a fallback,
+// before the route timeout answers with a 504.
+func WithHeadroom(headroom time.Duration) Option {
+	return func(t *transport) {
+		t.headroom = headroom
+	}
+}
+
+// New returns a client that retries requests which fail with a network error
+// or a 429, 502, 503 or 504 status, as long as they are idempotent: GET, HEAD,
+// OPTIONS, TRACE, PUT and DELETE requests, and those with an Idempotency-Key
+// header. Requests with a body are only retried if it can be read again
+// through GetBody, as http.NewRequest arranges for bodies it knows how to
+// copy.
+//
+// A Retry-After header on the response is honored if it asks for no more than
+// the longest backoff; otherwise the response is returned as it is. No attempt
+// is made that couldn't start before the request's deadline, less the
+// headroom.
+func New(opts ...Option) *http.Client {
+	t := &transport{
+		maxAttempts: defaultMaxAttempts,
+		minBackoff:  defaultMinBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		headroom:    defaultHeadroom,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.base == nil {
+		t.base = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	if t.hedger != nil {
+		t.base = &hedgedTransport{rt: t.base, h: t.hedger}
+	}
+	t.base = httpmw.TraceTransport(httpmw.DeadlineTransport(t.base))
+	return &http.Client{Transport: t}
+}
+
+type transport struct {
+	base        http.RoundTripper
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	timeout     time.Duration
+	headroom    time.Duration
+	hedger      *Hedger
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	cancel := context.CancelFunc(func() {})
+	deadline, hasDeadline := t.deadline(ctx)
+	if hasDeadline {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	}
+	retryable := idempotent(r) && (r.Body == nil || r.Body == http.NoBody || r.GetBody != nil)
+
+	for attempt := 1; ; attempt++ {
+		req := r.Clone(ctx)
+		if attempt > 1 && req.Body != nil && req.Body != http.NoBody {
+			body, err := r.GetBody()
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := t.base.RoundTrip(req)
+
+		wait, retry := t.backoff(attempt, resp, err)
+		retry = retry && retryable && attempt < t.maxAttempts && ctx.Err() == nil
+		if retry && hasDeadline && time.Now().Add(wait).After(deadline) {
+			retry = false
+		}
+		if !retry {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			// the deadline has to last until the body has been read
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		if resp != nil {
+			// reading a little of what's left lets the connection be reused
+			io.CopyN(io.Discard, resp.Body, 4<<10)
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			cancel()
+			return nil, context.Cause(ctx)
+		}
+	}
+}
+
+// deadline returns when a request made with ctx has to be done by: the
+// context's deadline less the headroom, or the client's timeout if that's
+// sooner.
+func (t *transport) deadline(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+	// without enough time left for headroom, use whatever there is
+	if ok && time.Until(deadline) > t.headroom {
+		deadline = deadline.Add(-t.headroom)
+	}
+	if t.timeout > 0 {
+		if timeout := time.Now().Add(t.timeout); !ok || timeout.Before(deadline) {
+			deadline, ok = timeout, true
+		}
+	}
+	return deadline, ok
+}
+
+// backoff reports whether the outcome of an attempt is worth retrying, and how
+// long to wait before doing so.
+func (t *transport) backoff(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if err == nil {
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		default:
+			return 0, false
+		}
+	}
+	backoff := t.minBackoff << (attempt - 1)
+	if backoff <= 0 || backoff > t.maxBackoff {
+		backoff = t.maxBackoff
+	}
+	wait := time.Duration(rand.Int64N(int64(backoff) + 1))
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if retryAfter > t.maxBackoff {
+				return 0, false
+			}
+			wait = max(wait, retryAfter)
+		}
+	}
+	return wait, true
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as a date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get(httpmw.IdempotencyKeyHeader) != ""
+}
+
+// cancelBody cancels the context of the request it is the response to once it
+// is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,70 @@
+package httpmw
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// AccessLogOption configures AccessLog.
+type AccessLogOption func(*accessLog)
+
+type accessLog struct {
+	skip map[string]bool
+}
+
+// SkipPaths leaves requests for the given paths out of the access log, such as
+// health checks that would otherwise drown out everything else.
+func SkipPaths(paths ...string) AccessLogOption {
+	return func(l *accessLog) {
+		for _, path := range paths {
+			l.skip[path] = true
+		}
+	}
+}
+
+// AccessLog logs a structured entry for every request once it has been served,
+// with its method, path, status, the bytes written, how long it took, its
+// request ID if RequestID gave it one, the client's IP address, and its trace
+// ID if Trace put it in one. Entries go to logger, or the request context's
+// logger, see LoggerFromContext, if it is nil.
+func AccessLog(logger *slog.Logger, opts ...AccessLogOption) Middleware {
+	l := accessLog{skip: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(&l)
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if l.skip[r.URL.Path] {
+				h.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			rw := NewResponseWriter(w)
+			h.ServeHTTP(rw, r)
+
+			status := rw.Status()
+			if status == 0 {
+				// the server sends a 200 for a handler that wrote nothing
+				status = http.StatusOK
+			}
+			logger := logger
+			if logger == nil {
+				logger = LoggerFromContext(r.Context())
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Int64("bytes", rw.Written()),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("request_id", RequestIDFromContext(r.Context())),
+				slog.String("client_ip", ClientIP(r)),
+			}
+			if tc, ok := TraceFromContext(r.Context()); ok {
+				attrs = append(attrs, slog.String("trace_id", tc.TraceID))
+			}
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package httpmw
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// APIKeyHeader is the header API keys are read from by default.
+const APIKeyHeader = "X-API-Key"
+
+// KeyLookup returns the name of the client key belongs to, or false if key
+// isn't valid. Any function can be a KeyLookup, e.g. one that looks keys up in
+// a database or a secrets manager.
+type KeyLookup func(ctx context.Context, key string) (client string, ok bool)
+
+// StaticKeys looks keys up in keys, which maps each client's name to its key.
+// Keys are compared in constant time, so how long a lookup takes doesn't give
+// away how much of a key was right.
+func StaticKeys(keys map[string]string) KeyLookup {
+	type entry struct{ client, key string }
+	entries := make([]entry, 0, len(keys))
+	for client, key := range keys {
+		if key != "" {
+			entries = append(entries, entry{client, key})
+		}
+	}
+	return func(ctx context.Context, key string) (string, bool) {
+		// check every key, rather than stopping at the right one
+		var client string
+		var found bool
+		for _, e := range entries {
+			if subtle.ConstantTimeCompare([]byte(e.key), []byte(key)) == 1 {
+				client, found = e.client, true
+			}
+		}
+		return client, found
+	}
+}
+
+// EnvKeys looks keys up in the environment variable name, read once when
+// EnvKeys is called, which holds a comma-separated list of client=key pairs:
+//
+//	API_KEYS=billing=3f9a...,reports=c41d...
+//
+// A key given without a client's name belongs to a client named "".
+func EnvKeys(name string) KeyLookup {
+	keys := make(map[string]string)
+	for pair := range strings.SplitSeq(os.Getenv(name), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		client, key, ok := strings.Cut(pair, "=")
+		if !ok {
+			client, key = "", pair
+		}
+		keys[client] = key
+	}
+	return StaticKeys(keys)
+}
+
+// APIKeyOption configures APIKey.
+type APIKeyOption func(*apiKeyAuth)
+
+// KeyHeader reads keys from the header name rather than X-API-Key. Keys are
+// also accepted as bearer tokens in the Authorization header either way.
+func KeyHeader(name string) APIKeyOption {
+	return func(a *apiKeyAuth) {
+		a.header = name
+	}
+}
+
+type apiKeyAuth struct {
+	lookup KeyLookup
+	header string
+}
+
+type apiClientKey struct{}
+
+// APIKey only lets requests carrying a valid API key through, in their
+// X-API-Key header or as their bearer token, and answers the rest with a 401
+// Unauthorized. It suits endpoints other services call, where issuing each one
+// a key is simpler than running JWT's infrastructure:
+//
+//	httpmw.APIKey(httpmw.EnvKeys("API_KEYS"))
+//
+// The name of the client the key belongs to is stored in the request's
+// context, for APIClientFromContext.
+func APIKey(lookup KeyLookup, opts ...APIKeyOption) Middleware {
+	a := &apiKeyAuth{lookup: lookup, header: APIKeyHeader}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(a.header)
+			if key == "" {
+				key, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			}
+			client, ok := "", false
+			if key != "" {
+				client, ok = a.lookup(r.Context(), key)
+			}
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiClientKey{}, client)))
+		})
+	}
+}
+
+// APIClientFromContext returns the name of the client whose API key the
+// request ctx belongs to was authenticated with, or false if it wasn't.
+func APIClientFromContext(ctx context.Context) (string, bool) {
+	client, ok := ctx.Value(apiClientKey{}).(string)
+	return client, ok
+}
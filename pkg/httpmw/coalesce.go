@@ -0,0 +1,142 @@
+package httpmw
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// CoalesceOption configures Coalesce.
+type CoalesceOption func(*coalescer)
+
+// CoalesceBy only coalesces requests for the same URL for which key returns the
+// same value, in place of their credentials. It can widen what is shared, e.g.
+// to every caller for public endpoints that ignore who is asking:
+//
+//	httpmw.CoalesceBy(func(r *http.Request) string {
+//		return ""
+//	})
+//
+// or narrow it to the caller's user ID for endpoints whose responses also
+// depend on what the token is for. The responses of requests with the same
+// key are shared, so key must tell apart every caller who could be sent a
+// different one.
+func CoalesceBy(key func(r *http.Request) string) CoalesceOption {
+	return func(c *coalescer) {
+		c.key = key
+	}
+}
+
+// Coalesce serves identical GET requests that arrive while one is already
+// being handled with that request's response, rather than handling each of
+// them, so a burst of requests for an expensive read only does the work once.
+// Requests are identical if they're for the same URL, including its query,
+// and carry the same Authorization and Cookie headers, so one caller is never
+// sent another's response. CoalesceBy changes what they have to share besides
+// the URL.
+//
+// The response is held in memory until the handler returns, so Coalesce suits
+// endpoints with small responses rather than streams, and waiting requests
+// share whatever the first one got, errors included. If the handler panics,
+// the waiting requests are handled on their own instead. Requests with other
+// methods are passed on as they are.
+func Coalesce(opts ...CoalesceOption) Middleware {
+	c := &coalescer{
+		key:   credentials,
+		calls: make(map[string]*coalescedCall),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				h.ServeHTTP(w, r)
+				return
+			}
+			key := r.Host + " " + r.URL.RequestURI() + " " + c.key(r)
+			c.mu.Lock()
+			if call, ok := c.calls[key]; ok {
+				c.mu.Unlock()
+				select {
+				case <-call.done:
+				case <-r.Context().Done():
+					return
+				}
+				if call.panicked {
+					h.ServeHTTP(w, r)
+					return
+				}
+				call.writeTo(w)
+				return
+			}
+			call := &coalescedCall{done: make(chan struct{}), header: make(http.Header), panicked: true}
+			c.calls[key] = call
+			c.mu.Unlock()
+
+			defer func() {
+				c.mu.Lock()
+				delete(c.calls, key)
+				c.mu.Unlock()
+				close(call.done)
+			}()
+			h.ServeHTTP(call, r)
+			call.panicked = false
+			call.writeTo(w)
+		})
+	}
+}
+
+// credentials is the default key of Coalesce: the Authorization and Cookie
+// headers.
+func credentials(r *http.Request) string {
+	return fmt.Sprintf("%q %q", r.Header.Values("Authorization"), r.Header.Values("Cookie"))
+}
+
+type coalescer struct {
+	key func(*http.Request) string
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall records the response to the request being handled for the
+// requests waiting on it. It is only read once done is closed.
+type coalescedCall struct {
+	done     chan struct{}
+	panicked bool
+
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (c *coalescedCall) Header() http.Header {
+	return c.header
+}
+
+func (c *coalescedCall) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+}
+
+func (c *coalescedCall) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	return c.body.Write(b)
+}
+
+// writeTo sends the recorded response to w.
+func (c *coalescedCall) writeTo(w http.ResponseWriter) {
+	dst := w.Header()
+	for k, v := range c.header {
+		dst[k] = append([]string(nil), v...)
+	}
+	if c.status != 0 {
+		w.WriteHeader(c.status)
+	}
+	w.Write(c.body.Bytes())
+}
@@ -0,0 +1,189 @@
+package httpmw
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOption configures CORS.
+type CORSOption func(*cors)
+
+type cors struct {
+	origins     []string
+	methods     []string
+	headers     []string
+	exposed     []string
+	credentials bool
+	maxAge      time.Duration
+}
+
+// AllowOrigins sets the origins browsers may make requests from, such as
+// "https://app.example.com". An origin may have a wildcard for its subdomains,
+// as in "https://*.example.com", and "*" allows every origin. No origins are
+// allowed by default, and the opaque origin "null", which sandboxed iframes and
+// data: URLs send, is never allowed, since any site can send it.
+func AllowOrigins(origins ...string) CORSOption {
+	return func(c *cors) {
+		c.origins = append(c.origins, origins...)
+	}
+}
+
+// AllowMethods sets the methods cross-origin requests may use. It defaults to
+// GET, HEAD and POST.
+func AllowMethods(methods ...string) CORSOption {
+	return func(c *cors) {
+		c.methods = methods
+	}
+}
+
+// AllowHeaders sets the request headers cross-origin requests may send, with
+// "*" allowing any. It defaults to Accept, Content-Type and X-Requested-With.
+func AllowHeaders(headers ...string) CORSOption {
+	return func(c *cors) {
+		c.headers = headers
+	}
+}
+
+// ExposeHeaders sets the response headers scripts may read beyond the basic
+// ones, such as X-Request-ID.
+func ExposeHeaders(headers ...string) CORSOption {
+	return func(c *cors) {
+		c.exposed = append(c.exposed, headers...)
+	}
+}
+
+// AllowCredentials lets cross-origin requests carry cookies and other
+// credentials. It can't be combined with AllowOrigins("*"), which would let
+// every site act with the user's cookies and read the responses.
+func AllowCredentials() CORSOption {
+	return func(c *cors) {
+		c.credentials = true
+	}
+}
+
+// MaxAge sets how long browsers may cache the result of a preflight request,
+// so they don't send one before every request.
+func MaxAge(d time.Duration) CORSOption {
+	return func(c *cors) {
+		c.maxAge = d
+	}
+}
+
+// CORS lets browsers make requests to the handler from the origins allowed by
+// opts, answering preflight requests itself and adding the CORS headers to the
+// responses to the others. Requests from origins that aren't allowed are passed
+// on without the headers, so browsers keep scripts from reading the responses.
+// CORS panics if opts allow credentials from every origin.
+//
+//	httpmw.CORS(
+//		httpmw.AllowOrigins("https://app.example.com"),
+//		httpmw.AllowMethods("GET", "POST", "DELETE"),
+//		httpmw.MaxAge(time.Hour),
+//	)
+func CORS(opts ...CORSOption) Middleware {
+	c := &cors{
+		methods: []string{http.MethodGet, http.MethodHead, http.MethodPost},
+		headers: []string{"Accept", "Content-Type", "X-Requested-With"},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.credentials && slices.Contains(c.origins, "*") {
+		panic("httpmw: CORS can't allow credentials from every origin")
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			w.Header().Add("Vary", "Origin")
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+				if origin != "" && c.allowOrigin(origin) {
+					c.preflight(w, r, origin)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if origin != "" && c.allowOrigin(origin) {
+				c.setOrigin(w, origin)
+				if len(c.exposed) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.exposed, ", "))
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// preflight answers a preflight request from an allowed origin, unless it asks
+// for a method or headers that aren't allowed.
+func (c *cors) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !slices.ContainsFunc(c.methods, func(m string) bool { return strings.EqualFold(m, method) }) {
+		return
+	}
+	var headers []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for header := range strings.SplitSeq(v, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				headers = append(headers, header)
+			}
+		}
+	}
+	for _, header := range headers {
+		if !c.allowHeader(header) {
+			return
+		}
+	}
+
+	c.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.methods, ", "))
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if c.maxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.maxAge.Seconds())))
+	}
+}
+
+// setOrigin tells the browser origin may read the response, with a wildcard
+// if every origin may.
+func (c *cors) setOrigin(w http.ResponseWriter, origin string) {
+	if slices.Contains(c.origins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *cors) allowOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	if origin == "null" {
+		return false
+	}
+	for _, allowed := range c.origins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok {
+			if rest, ok := strings.CutPrefix(origin, scheme+"://"); ok && strings.HasSuffix(rest, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *cors) allowHeader(header string) bool {
+	return slices.ContainsFunc(c.headers, func(h string) bool {
+		return h == "*" || strings.EqualFold(h, header)
+	})
+}
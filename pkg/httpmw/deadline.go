@@ -0,0 +1,51 @@
+package httpmw
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DeadlineHeader is the header the time a caller has left for a request is
+// passed in, as a whole number of milliseconds.
+const DeadlineHeader = "X-Request-Timeout-Ms"
+
+// Deadline gives each request the deadline its caller passed in the
+// X-Request-Timeout-Ms header, if that's sooner than the one it already has,
+// so the request doesn't carry on after the caller has given up on it. Along
+// with DeadlineTransport, a chain of calls between services shares the time
+// budget of the first. Requests without a valid header are passed on as they
+// are.
+func Deadline(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms, err := strconv.ParseInt(r.Header.Get(DeadlineHeader), 10, 64)
+		if err != nil || ms < 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(ms)*time.Millisecond)
+		defer cancel()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// DeadlineTransport passes the time left until the deadline of each request's
+// context on to the service it is sent to, in the X-Request-Timeout-Ms header,
+// for Deadline to read. Requests without a deadline, or that already have the
+// header, are sent as they are. A nil rt means http.DefaultTransport.
+func DeadlineTransport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		deadline, ok := r.Context().Deadline()
+		if !ok || r.Header.Get(DeadlineHeader) != "" {
+			return rt.RoundTrip(r)
+		}
+		r = r.Clone(r.Context())
+		left := max(time.Until(deadline), 0)
+		r.Header.Set(DeadlineHeader, strconv.FormatInt(left.Milliseconds(), 10))
+		return rt.RoundTrip(r)
+	})
+}
@@ -0,0 +1,44 @@
+// Package httpmw provides HTTP middleware for the servers run by the daemon,
+// and Chain for composing it, so behavior that applies to every request, such
+// as logging or authentication, doesn't have to be wired into each handler.
+//
+// Middleware can wrap a handler directly, or be given to the daemon to wrap
+// every server it runs:
+//
+//	d := daemon.New(mux, daemon.WithMiddleware(recoverPanics, logRequests))
+package httpmw
+
+import (
+	"net"
+	"net/http"
+	"slices"
+)
+
+// Middleware wraps a handler with behavior of its own, before or after passing
+// the request on.
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes mw into a single Middleware. The first one wraps the rest, so
+// it sees each request first and its response last:
+//
+//	handler := httpmw.Chain(recoverPanics, logRequests, authenticate)(mux)
+func Chain(mw ...Middleware) Middleware {
+	mw = slices.Clone(mw)
+	return func(h http.Handler) http.Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			h = mw[i](h)
+		}
+		return h
+	}
+}
+
+// ClientIP returns the IP address of the client r came from, without its port.
+// Behind a load balancer, this is only the client's if the load balancer passes
+// it on with the PROXY protocol, which the daemon's ProxyProtocol reads.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,277 @@
+package httpmw
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the header clients send idempotency keys in.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ErrIdempotencyKeyInUse is returned by an IdempotencyStore's Reserve when
+// another request with the same key is still being handled.
+var ErrIdempotencyKeyInUse = errors.New("idempotency key is in use")
+
+// StoredResponse is a response saved for an idempotency key, to be sent again
+// to retries of the request.
+type StoredResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Fingerprint is a hash of the request the response was to, its body,
+	// query and Content-Type, so a retry that isn't the same request can be
+	// told apart. Stores must keep it along with the response.
+	Fingerprint string
+}
+
+// IdempotencyStore holds the responses to requests made with idempotency keys.
+// Keys must be reserved by one request at a time, so a store shared by several
+// instances, e.g. one backed by Redis or a database, must reserve them
+// atomically.
+type IdempotencyStore interface {
+	// Reserve claims key for a request about to be handled, returning nil. If
+	// a response has been saved for key, it is returned instead, and if
+	// another request holds key, Reserve returns ErrIdempotencyKeyInUse.
+	Reserve(ctx context.Context, key string) (*StoredResponse, error)
+	// Save saves the response to the request holding key.
+	Save(ctx context.Context, key string, resp *StoredResponse) error
+	// Release gives up key without saving a response, so it can be used again.
+	Release(ctx context.Context, key string) error
+}
+
+// IdempotencyOption configures Idempotency.
+type IdempotencyOption func(*idempotency)
+
+// IdempotencyScope keeps clients' keys apart, so two clients picking the same
+// key don't get each other's responses, by prefixing each key with what scope
+// returns, such as the caller's user ID:
+//
+//	httpmw.IdempotencyScope(func(r *http.Request) string {
+//		claims, _ := httpmw.ClaimsFromContext(r.Context())
+//		return claims.Subject()
+//	})
+func IdempotencyScope(scope func(r *http.Request) string) IdempotencyOption {
+	return func(i *idempotency) {
+		i.scope = scope
+	}
+}
+
+type idempotency struct {
+	store IdempotencyStore
+	scope func(*http.Request) string
+}
+
+// Idempotency makes POST and PATCH requests carrying an Idempotency-Key header
+// safe to retry, such as after a timeout or a 503 from a draining instance, by
+// saving the response to the first request with each key and sending it again
+// to the retries rather than handling them, with an Idempotent-Replayed
+// header. A retry that arrives while the first request is still being handled
+// gets a 409 Conflict, and one that reuses the key for a different request, with
+// another body, query or Content-Type, gets a 422 Unprocessable Content rather
+// than the first request's response. Keys are scoped to the method and path
+// they were used with. The body is read into memory to be compared.
+//
+// Responses with a 5xx status aren't saved, and neither are those of handlers
+// that panic, so the request can be retried for real. Requests without a key,
+// and with other methods, are passed on as they are.
+func Idempotency(store IdempotencyStore, opts ...IdempotencyOption) Middleware {
+	i := &idempotency{store: store}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || r.Method != http.MethodPost && r.Method != http.MethodPatch {
+				h.ServeHTTP(w, r)
+				return
+			}
+			key = r.Method + " " + r.URL.Path + " " + key
+			if i.scope != nil {
+				key = i.scope(r) + " " + key
+			}
+			fingerprint, err := fingerprintRequest(r)
+			if err != nil {
+				status := http.StatusBadRequest
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					status = http.StatusRequestEntityTooLarge
+				}
+				http.Error(w, "reading request body: "+err.Error(), status)
+				return
+			}
+
+			saved, err := i.store.Reserve(r.Context(), key)
+			switch {
+			case errors.Is(err, ErrIdempotencyKeyInUse):
+				http.Error(w, "a request with this idempotency key is in progress", http.StatusConflict)
+				return
+			case err != nil:
+				LoggerFromContext(r.Context()).Error("reserving idempotency key", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			case saved != nil && saved.Fingerprint != "" && saved.Fingerprint != fingerprint:
+				http.Error(w, "this idempotency key was used for a different request", http.StatusUnprocessableEntity)
+				return
+			case saved != nil:
+				// headers the middleware outside already set, such as the
+				// request ID, belong to this request rather than the first
+				dst := w.Header()
+				for k, v := range saved.Header {
+					if _, ok := dst[k]; !ok {
+						dst[k] = v
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(saved.Status)
+				w.Write(saved.Body)
+				return
+			}
+
+			// the key has to be saved or released even if the client has gone
+			ctx := context.WithoutCancel(r.Context())
+			rw := &recordingWriter{ResponseWriter: w}
+			saveResponse := false
+			defer func() {
+				if saveResponse {
+					err = i.store.Save(ctx, key, &StoredResponse{Status: rw.status, Header: rw.header, Body: rw.body.Bytes(), Fingerprint: fingerprint})
+				} else {
+					err = i.store.Release(ctx, key)
+				}
+				if err != nil {
+					LoggerFromContext(ctx).Error("storing idempotency key", "err", err)
+				}
+			}()
+			h.ServeHTTP(rw, r)
+			if rw.status == 0 {
+				rw.WriteHeader(http.StatusOK)
+			}
+			saveResponse = rw.status < 500
+		})
+	}
+}
+
+// fingerprintRequest hashes what makes r the request it is besides its method
+// and path, which are part of the key: its query, Content-Type and body. The
+// body is read into memory and put back for the handler.
+func fingerprintRequest(r *http.Request) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q\n", r.URL.RawQuery, r.Header.Get("Content-Type"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// recordingWriter keeps a copy of the response it passes on.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.status == 0 && code >= 200 {
+		rw.status = code
+		rw.header = rw.ResponseWriter.Header().Clone()
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore that keeps responses in memory
+// for a while. It only suits a single instance, as the instances behind a
+// load balancer don't share it.
+type MemoryIdempotencyStore struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+type idempotencyEntry struct {
+	// resp is nil while the key is reserved
+	resp    *StoredResponse
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns a store that keeps responses for ttl, such
+// as 24 hours, after which their keys can be used again.
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{ttl: ttl, entries: make(map[string]*idempotencyEntry)}
+}
+
+// Reserve implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Reserve(ctx context.Context, key string) (*StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		if e.resp == nil {
+			return nil, ErrIdempotencyKeyInUse
+		}
+		return e.resp, nil
+	}
+	// a reservation expires too, in case whatever held it never let go
+	s.entries[key] = &idempotencyEntry{expires: now.Add(s.ttl)}
+	return nil, nil
+}
+
+// Save implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Save(ctx context.Context, key string, resp *StoredResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = &idempotencyEntry{resp: resp, expires: time.Now().Add(s.ttl)}
+	return nil
+}
+
+// Release implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep forgets expired entries, about once a minute.
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package httpmw
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPFilter only lets requests from clients whose IP address, as returned by
+// ClientIP, is in one of the allow ranges and none of the deny ranges through,
+// and answers the rest with a 403 Forbidden. An empty allow list allows every
+// address that isn't denied, so deny can be used on its own to block a few
+// ranges. IPv4 addresses are matched whether or not they come mapped into
+// IPv6.
+func IPFilter(allow, deny []netip.Prefix) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !IPAllowed(r, allow, deny) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IPAllowed reports whether IPFilter, given the same ranges, would let r
+// through, for handlers that want to treat other clients differently rather
+// than turn them away.
+func IPAllowed(r *http.Request, allow, deny []netip.Prefix) bool {
+	return allowIP(ClientIP(r), allow, deny)
+}
+
+func allowIP(client string, allow, deny []netip.Prefix) bool {
+	// a zone, as in fe80::1%eth0, doesn't matter for matching
+	client, _, _ = strings.Cut(client, "%")
+	addr, err := netip.ParseAddr(client)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range deny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, p := range allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCIDRs parses address ranges for IPFilter, such as "10.0.0.0/8" or
+// "fd00::/8". A single address, such as "127.0.0.1", is a range of its own.
+func ParseCIDRs(cidrs ...string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		var p netip.Prefix
+		var err error
+		if strings.Contains(cidr, "/") {
+			p, err = netip.ParsePrefix(cidr)
+		} else {
+			var addr netip.Addr
+			addr, err = netip.ParseAddr(cidr)
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad address range %q: %w", cidr, err)
+		}
+		// match mapped IPv4 ranges against plain IPv4 addresses
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,271 @@
+package httpmw
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksMaxAge is how long keys are cached when the JWKS response doesn't say.
+	jwksMaxAge = time.Hour
+	// jwksMinRefresh keeps tokens with made-up key IDs, and an identity
+	// provider that is down, from making the set be fetched over and over.
+	jwksMinRefresh = time.Minute
+)
+
+// JWKS is a JSON Web Key Set fetched from a URL, such as an identity
+// provider's https://example.com/.well-known/jwks.json, holding the public keys
+// JWT verifies tokens with.
+//
+// The keys are cached for as long as the response's Cache-Control max-age
+// allows, an hour by default, and fetched again when a token is signed with a
+// key that isn't in the cache, so keys the provider rotates in are picked up
+// without waiting for the cache to expire. Once the cache has expired, the
+// keys in it stay in use while the set is fetched again in the background,
+// and for as long as it can't be. Either way, the set is fetched at most once
+// a minute, and only by one request at a time, so an identity provider that is
+// down doesn't hold up every request.
+type JWKS struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]jwk
+	expires time.Time
+	fetched time.Time
+	// fetching is the fetch under way, if any
+	fetching *jwksFetch
+}
+
+// jwksFetch is a fetch of the set, whose err is set once done is closed.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// jwk is a public key from the set, along with the algorithm it is for, if the
+// set says.
+type jwk struct {
+	key crypto.PublicKey
+	alg string
+}
+
+// NewJWKS returns the key set served at url. Nothing is fetched until the
+// first token is verified.
+func NewJWKS(url string) *JWKS {
+	return &JWKS{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// key returns the key with the ID kid, or the only key in the set if kid is ""
+// and the set has just one. Only a key that isn't cached waits for the set to
+// be fetched.
+func (s *JWKS) key(ctx context.Context, kid string) (jwk, error) {
+	s.mu.Lock()
+	now := time.Now()
+	k, ok := s.lookup(kid)
+	if ok && now.Before(s.expires) {
+		s.mu.Unlock()
+		return k, nil
+	}
+	f := s.fetching
+	if f == nil && (s.keys == nil || now.Sub(s.fetched) >= jwksMinRefresh) {
+		f = s.refresh(ctx, now)
+	}
+	s.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	if f == nil {
+		return jwk{}, fmt.Errorf("no key with ID %q", kid)
+	}
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return jwk{}, ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.lookup(kid); ok {
+		return k, nil
+	}
+	if f.err != nil {
+		return jwk{}, f.err
+	}
+	return jwk{}, fmt.Errorf("no key with ID %q", kid)
+}
+
+func (s *JWKS) lookup(kid string) (jwk, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// refresh starts fetching the set in the background, replacing the cached keys
+// if it succeeds. The fetch outlives the request that started it, keeping only
+// its values, so the other requests waiting for it aren't failed if that one
+// goes away. It must be called with mu held.
+func (s *JWKS) refresh(ctx context.Context, now time.Time) *jwksFetch {
+	f := &jwksFetch{done: make(chan struct{})}
+	s.fetching = f
+	s.fetched = now
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		keys, maxAge, err := s.fetch(ctx)
+		s.mu.Lock()
+		if err == nil {
+			s.keys = keys
+			s.expires = now.Add(maxAge)
+		}
+		s.fetching = nil
+		f.err = err
+		s.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// fetch returns the keys served at the set's URL, and how long to cache them.
+func (s *JWKS) fetch(ctx context.Context) (map[string]jwk, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("fetching JWKS: %s", resp.Status)
+	}
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, 0, fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]jwk, len(set.Keys))
+	for _, raw := range set.Keys {
+		kid, k, err := parseJWK(raw)
+		if err != nil {
+			// skip keys of types this doesn't know, rather than failing on
+			// them all
+			continue
+		}
+		keys[kid] = k
+	}
+	if len(keys) == 0 {
+		return nil, 0, errors.New("JWKS has no usable keys")
+	}
+	return keys, maxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+// maxAge returns the max-age in a Cache-Control header, or jwksMaxAge.
+func maxAge(cacheControl string) time.Duration {
+	for directive := range strings.SplitSeq(cacheControl, ",") {
+		v, ok := strings.CutPrefix(strings.TrimSpace(directive), "max-age=")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return jwksMaxAge
+}
+
+// parseJWK parses an RSA, EC or Ed25519 public key meant for signatures.
+func parseJWK(raw []byte) (string, jwk, error) {
+	var k struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return "", jwk{}, err
+	}
+	if k.Use != "" && k.Use != "sig" {
+		return "", jwk{}, fmt.Errorf("key %q isn't for signatures", k.Kid)
+	}
+
+	var key crypto.PublicKey
+	switch k.Kty {
+	case "RSA":
+		n, err1 := decodeBigInt(k.N)
+		e, err2 := decodeBigInt(k.E)
+		if err := errors.Join(err1, err2); err != nil {
+			return "", jwk{}, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return "", jwk{}, errors.New("RSA exponent is too large")
+		}
+		key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return "", jwk{}, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err1 := decodeBigInt(k.X)
+		y, err2 := decodeBigInt(k.Y)
+		if err := errors.Join(err1, err2); err != nil {
+			return "", jwk{}, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return "", jwk{}, errors.New("EC point isn't on its curve")
+		}
+		key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return "", jwk{}, errors.New("unsupported OKP key")
+		}
+		key = ed25519.PublicKey(x)
+	default:
+		return "", jwk{}, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	return k.Kid, jwk{key: key, alg: k.Alg}, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("bad key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,314 @@
+package httpmw
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256" // for crypto.SHA256.New
+	_ "crypto/sha512" // for crypto.SHA384.New and crypto.SHA512.New
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Claims are the claims of a verified JWT, decoded from JSON.
+type Claims map[string]any
+
+// Subject returns the "sub" claim, usually the ID of the user or service the
+// token was issued to.
+func (c Claims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+// Issuer returns the "iss" claim.
+func (c Claims) Issuer() string {
+	s, _ := c["iss"].(string)
+	return s
+}
+
+// Audience returns the "aud" claim, which may be a single string or a list.
+func (c Claims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		var auds []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+		return auds
+	}
+	return nil
+}
+
+// time returns the time in the NumericDate claim name, if the token has it.
+func (c Claims) time(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%q claim isn't a number", name)
+	}
+	sec := int64(n)
+	return time.Unix(sec, int64((n-float64(sec))*1e9)), true, nil
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the JWT the request ctx belongs to
+// was authenticated with, or false if it wasn't.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(Claims)
+	return c, ok
+}
+
+// ContextWithClaims returns a copy of ctx carrying claims, e.g. for work that
+// is picked up from a queue on behalf of the user who queued it.
+func ContextWithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// JWTOption configures JWT.
+type JWTOption func(*jwtAuth)
+
+// RequireIssuer only accepts tokens whose "iss" claim is issuer.
+func RequireIssuer(issuer string) JWTOption {
+	return func(a *jwtAuth) {
+		a.issuer = issuer
+	}
+}
+
+// RequireAudience only accepts tokens whose "aud" claim includes audience,
+// usually the service's own name or URL.
+func RequireAudience(audience string) JWTOption {
+	return func(a *jwtAuth) {
+		a.audience = audience
+	}
+}
+
+// ClockSkew accepts tokens that expired, or only become valid, up to d ago or
+// from now, to allow for the clocks of the issuer and the server not quite
+// agreeing.
+func ClockSkew(d time.Duration) JWTOption {
+	return func(a *jwtAuth) {
+		a.skew = d
+	}
+}
+
+// SkipAuth lets requests matching patterns through without a token, such as
+// "GET /public/" or "POST /webhooks/{provider}". Routes are matched as they
+// would be by an http.ServeMux. A request for a skipped route that does carry a
+// valid token still has its claims in its context.
+func SkipAuth(patterns ...string) JWTOption {
+	return func(a *jwtAuth) {
+		a.skip = append(a.skip, patterns...)
+	}
+}
+
+// JWT only lets requests carrying a valid JWT as their bearer token through,
+// verifying its signature with a key from keys and checking that it hasn't
+// expired, and answers the rest with a 401 Unauthorized. The token's claims
+// are stored in the request's context, for ClaimsFromContext:
+//
+//	jwks := httpmw.NewJWKS("https://auth.example.com/.well-known/jwks.json")
+//	httpmw.JWT(jwks,
+//		httpmw.RequireIssuer("https://auth.example.com/"),
+//		httpmw.RequireAudience("orders"),
+//		httpmw.SkipAuth("GET /docs/"),
+//	)
+//
+// Tokens signed with RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384,
+// ES512 or EdDSA are accepted. Unsigned tokens never are.
+func JWT(keys *JWKS, opts ...JWTOption) Middleware {
+	a := &jwtAuth{keys: keys}
+	for _, opt := range opts {
+		opt(a)
+	}
+	var skip *http.ServeMux
+	if len(a.skip) > 0 {
+		skip = http.NewServeMux()
+		for _, pattern := range a.skip {
+			skip.Handle(pattern, http.NotFoundHandler())
+		}
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			optional := false
+			if skip != nil {
+				_, pattern := skip.Handler(r)
+				optional = pattern != ""
+			}
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				if optional {
+					h.ServeHTTP(w, r)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			claims, err := a.verify(r.Context(), token, time.Now())
+			if err != nil {
+				if optional {
+					h.ServeHTTP(w, r)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+type jwtAuth struct {
+	keys     *JWKS
+	issuer   string
+	audience string
+	skew     time.Duration
+	skip     []string
+}
+
+// verify checks token's signature and claims, and returns the claims.
+func (a *jwtAuth) verify(ctx context.Context, token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token doesn't have three parts")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %w", err)
+	}
+	k, err := a.keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if k.alg != "" && k.alg != header.Alg {
+		return nil, fmt.Errorf("key %q is for %s, not %s", header.Kid, k.alg, header.Alg)
+	}
+	if err := verifySignature(header.Alg, k.key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decoding claims: %w", err)
+	}
+	if exp, ok, err := claims.time("exp"); err != nil {
+		return nil, err
+	} else if ok && !now.Before(exp.Add(a.skew)) {
+		return nil, errors.New("token has expired")
+	}
+	if nbf, ok, err := claims.time("nbf"); err != nil {
+		return nil, err
+	} else if ok && now.Add(a.skew).Before(nbf) {
+		return nil, errors.New("token isn't valid yet")
+	}
+	if a.issuer != "" && claims.Issuer() != a.issuer {
+		return nil, fmt.Errorf("token was issued by %q", claims.Issuer())
+	}
+	if a.audience != "" && !slices.Contains(claims.Audience(), a.audience) {
+		return nil, fmt.Errorf("token isn't meant for %q", a.audience)
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature checks that sig is signed over signed with key using alg,
+// and that key is the right type for alg, so a token can't pick an algorithm
+// that makes a public key do something it wasn't meant to.
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	var h crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		h = crypto.SHA256
+	case "384":
+		h = crypto.SHA384
+	case "512":
+		h = crypto.SHA512
+	}
+	digest := func() []byte {
+		hh := h.New()
+		hh.Write([]byte(signed))
+		return hh.Sum(nil)
+	}
+
+	errBad := errors.New("bad signature")
+	switch {
+	case alg == "EdDSA":
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			break
+		}
+		if !ed25519.Verify(k, []byte(signed), sig) {
+			return errBad
+		}
+		return nil
+	case h == 0:
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			break
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(k, h, digest(), sig)
+		} else {
+			err = rsa.VerifyPSS(k, h, digest(), sig, nil)
+		}
+		if err != nil {
+			return errBad
+		}
+		return nil
+	case strings.HasPrefix(alg, "ES"):
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			break
+		}
+		// each curve goes with its own hash, P-521 with SHA-512
+		bits := k.Curve.Params().BitSize
+		if bits != h.Size()*8 && !(bits == 521 && h == crypto.SHA512) {
+			break
+		}
+		size := (bits + 7) / 8
+		if len(sig) != 2*size {
+			return errBad
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest(), r, s) {
+			return errBad
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q for the key", alg)
+}
@@ -0,0 +1,24 @@
+package httpmw
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying logger, which
+// LoggerFromContext returns.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the logger ctx carries, or slog.Default() if it
+// doesn't carry one. The daemon gives every request the daemon's logger, so
+// middleware and handlers log the same way the daemon does.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok && logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
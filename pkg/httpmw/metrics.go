@@ -0,0 +1,222 @@
+package httpmw
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forgeutah/utah-go/pkg/metrics"
+)
+
+// DurationBuckets are the upper bounds of the buckets request durations are
+// counted in.
+var DurationBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// unmatchedRoute is the route recorded for requests that weren't routed by an
+// http.ServeMux, or that didn't match any of its patterns.
+const unmatchedRoute = "unmatched"
+
+// RouteMetrics holds the rate, errors and duration (RED) metrics of the
+// requests one server has handled for one route.
+type RouteMetrics struct {
+	Server string
+	// Route is the http.ServeMux pattern the requests matched, such as
+	// "GET /users/{id}", or "unmatched".
+	Route string
+	// Classes[i] is the number of responses with a status of ixx, e.g.
+	// Classes[5] counts the 5xx responses.
+	Classes [6]uint64
+	// Buckets[i] is the number of requests that took at most
+	// DurationBuckets[i]. The counts are cumulative, as in a Prometheus
+	// histogram.
+	Buckets []uint64
+	// DurationSum is the total time spent serving the requests.
+	DurationSum time.Duration
+}
+
+// Count returns the number of requests served.
+func (m RouteMetrics) Count() uint64 {
+	var n uint64
+	for _, c := range m.Classes {
+		n += c
+	}
+	return n
+}
+
+// Errors returns the number of requests answered with a 5xx status.
+func (m RouteMetrics) Errors() uint64 {
+	return m.Classes[5]
+}
+
+// RequestMetrics records RED metrics for the requests passing through its
+// middleware, by server, route and status class.
+type RequestMetrics struct {
+	mu     sync.Mutex
+	routes map[routeKey]*RouteMetrics
+}
+
+type routeKey struct {
+	server, route string
+}
+
+// NewRequestMetrics returns an empty RequestMetrics.
+func NewRequestMetrics() *RequestMetrics {
+	return &RequestMetrics{routes: make(map[routeKey]*RouteMetrics)}
+}
+
+// Middleware records the requests to the named server. The route is the
+// pattern of the http.ServeMux that handled the request, which ServeMux only
+// sets on the request it is given, so if other middleware replaces the request
+// on its way to the mux, as most middleware that changes its context does,
+// wrap the mux itself in CaptureRoute.
+func (m *RequestMetrics) Middleware(server string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := NewResponseWriter(w)
+			pattern := ServeRoute(h, rw, r)
+			if pattern == "" {
+				pattern = unmatchedRoute
+			}
+			status := rw.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			m.record(server, pattern, status, time.Since(start))
+		})
+	}
+}
+
+func (m *RequestMetrics) record(server, route string, status int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := routeKey{server: server, route: route}
+	rm, ok := m.routes[key]
+	if !ok {
+		rm = &RouteMetrics{Server: server, Route: route, Buckets: make([]uint64, len(DurationBuckets))}
+		m.routes[key] = rm
+	}
+	rm.Classes[min(max(status/100, 0), 5)]++
+	rm.DurationSum += duration
+	for i, bound := range DurationBuckets {
+		if duration <= bound {
+			rm.Buckets[i]++
+		}
+	}
+}
+
+// Metrics returns the metrics of every route that has been requested, sorted by
+// server and route.
+func (m *RequestMetrics) Metrics() []RouteMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]RouteMetrics, 0, len(m.routes))
+	for _, rm := range m.routes {
+		c := *rm
+		c.Buckets = slices.Clone(rm.Buckets)
+		out = append(out, c)
+	}
+	slices.SortFunc(out, func(a, b RouteMetrics) int {
+		if c := strings.Compare(a.Server, b.Server); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Route, b.Route)
+	})
+	return out
+}
+
+// Handler returns an http.Handler that serves the metrics in the Prometheus
+// text format, as http_requests_total counters labeled by server, route and
+// status class, and an http_request_duration_seconds histogram per route.
+func (m *RequestMetrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes the metrics Handler serves to w, which makes m a
+// metrics.Collector.
+func (m *RequestMetrics) WriteMetrics(w io.Writer) {
+	all := m.Metrics()
+
+	fmt.Fprintln(w, "# HELP http_requests_total Requests served by status class.")
+	fmt.Fprintln(w, "# TYPE http_requests_total counter")
+	for _, rm := range all {
+		server := metrics.QuoteLabel(rm.Server)
+		route := metrics.QuoteLabel(rm.Route)
+		for class := 1; class <= 5; class++ {
+			fmt.Fprintf(w, "http_requests_total{server=%s,route=%s,class=\"%dxx\"} %d\n", server, route, class, rm.Classes[class])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP http_request_duration_seconds How long requests take to serve.")
+	fmt.Fprintln(w, "# TYPE http_request_duration_seconds histogram")
+	for _, rm := range all {
+		server := metrics.QuoteLabel(rm.Server)
+		route := metrics.QuoteLabel(rm.Route)
+		for i, bound := range DurationBuckets {
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{server=%s,route=%s,le=\"%g\"} %d\n", server, route, bound.Seconds(), rm.Buckets[i])
+		}
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{server=%s,route=%s,le=\"+Inf\"} %d\n", server, route, rm.Count())
+		fmt.Fprintf(w, "http_request_duration_seconds_sum{server=%s,route=%s} %g\n", server, route, rm.DurationSum.Seconds())
+		fmt.Fprintf(w, "http_request_duration_seconds_count{server=%s,route=%s} %d\n", server, route, rm.Count())
+	}
+}
+
+type routeHolderKey struct{}
+
+// withRouteHolder returns a copy of ctx holding somewhere for CaptureRoute to
+// put the route, unless ctx already has one.
+func withRouteHolder(ctx context.Context) (context.Context, *string) {
+	if route, ok := ctx.Value(routeHolderKey{}).(*string); ok {
+		return ctx, route
+	}
+	route := new(string)
+	return context.WithValue(ctx, routeHolderKey{}, route), route
+}
+
+// ServeRoute serves r with h and returns the pattern that matched it, as
+// CaptureRoute reports it from further in, or as h set it on r, or "" if
+// neither knows the route.
+func ServeRoute(h http.Handler, w http.ResponseWriter, r *http.Request) string {
+	ctx, route := withRouteHolder(r.Context())
+	r = r.WithContext(ctx)
+	h.ServeHTTP(w, r)
+	if *route != "" {
+		return *route
+	}
+	return r.Pattern
+}
+
+// CaptureRoute wraps an http.ServeMux, or a handler that sets r.Pattern the same
+// way, so the pattern that matched is known to middleware further out, such
+// as RequestMetrics, even if the request was replaced on its way in.
+func CaptureRoute(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// deferred so the route of a handler that panics is known too
+		defer func() {
+			if route, ok := r.Context().Value(routeHolderKey{}).(*string); ok && r.Pattern != "" {
+				*route = r.Pattern
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,121 @@
+package httpmw
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitOption configures RateLimit.
+type RateLimitOption func(*rateLimiter)
+
+// LimitBy sets what requests are limited by, such as an API key:
+//
+//	httpmw.LimitBy(func(r *http.Request) string {
+//		return r.Header.Get("X-API-Key")
+//	})
+//
+// Requests for which key returns "" aren't limited. By default requests are
+// limited by ClientIP.
+func LimitBy(key func(r *http.Request) string) RateLimitOption {
+	return func(l *rateLimiter) {
+		l.key = key
+	}
+}
+
+// RateLimit limits each client to perSecond requests a second on average, with
+// bursts of up to burst requests, using a token bucket per client. Requests
+// over the limit are answered with a 429 Too Many Requests and a Retry-After
+// header saying when the client can try again. RateLimit panics if perSecond
+// isn't positive or burst is less than 1, which would let no requests through.
+func RateLimit(perSecond float64, burst int, opts ...RateLimitOption) Middleware {
+	if !(perSecond > 0) || burst < 1 {
+		panic(fmt.Sprintf("httpmw: invalid rate limit of %v a second with bursts of %d", perSecond, burst))
+	}
+	l := &rateLimiter{
+		rate:    perSecond,
+		burst:   float64(burst),
+		key:     ClientIP,
+		buckets: make(map[string]*tokenBucket),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := l.key(r)
+			if key == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if wait, ok := l.take(key, time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1)))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	key   func(*http.Request) string
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket holds the tokens a client had left when it last made a request.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from the bucket for key, or reports how long it will be
+// until there is one.
+func (l *rateLimiter) take(key string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return seconds((1 - b.tokens) / l.rate), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// sweep forgets the buckets that have filled up again, about once a minute, so
+// clients that have gone away don't hold on to memory. A bucket that is full is
+// the same as no bucket at all.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	refill := seconds(l.burst / l.rate)
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// seconds converts a number of seconds to a Duration, capping it rather than
+// overflowing for the very low rates that take years to refill.
+func seconds(s float64) time.Duration {
+	return time.Duration(min(s, float64(math.MaxInt64/int64(time.Second))) * float64(time.Second))
+}
@@ -0,0 +1,47 @@
+package httpmw
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover recovers from panics in the handler, so one bad request gets a 500
+// Internal Server Error instead of a dropped connection. The panic is logged to
+// the request context's logger with its stack and the request it happened on,
+// and passed to onPanic, if it isn't nil, e.g. to count it. If the response
+// had already started, the connection is closed instead, since the client
+// can't be told about the error. Panics with http.ErrAbortHandler, which abort
+// a request on purpose, are left alone.
+func Recover(onPanic func(r *http.Request, v any, stack []byte)) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := NewResponseWriter(w)
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				stack := debug.Stack()
+				LoggerFromContext(r.Context()).Error("panic serving request",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"remote_addr", r.RemoteAddr,
+					"request_id", RequestIDFromContext(r.Context()),
+					"panic", fmt.Sprint(v),
+					"stack", string(stack))
+				if onPanic != nil {
+					onPanic(r, v, stack)
+				}
+				if rw.Status() != 0 {
+					panic(http.ErrAbortHandler)
+				}
+				http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}()
+			h.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package httpmw
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+)
+
+// RequestIDHeader is the header request IDs are read from and returned in.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength keeps a client from filling logs with a huge ID.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID gives every request an ID, so its log lines can be tied together
+// and to those of the services it calls. The ID comes from the request's
+// X-Request-ID header when a proxy or another service has already assigned
+// one, and is otherwise generated. It is stored in the request's context, for
+// RequestIDFromContext, and returned in the response's X-Request-ID header.
+func RequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(ContextWithRequestID(r.Context(), id)))
+	})
+}
+
+// RequestIDFromContext returns the ID of the request ctx belongs to, or "" if
+// it doesn't have one. Pass it on in the X-Request-ID header of requests to
+// other services.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ContextWithRequestID returns a copy of ctx carrying id, e.g. for work that is
+// picked up from a queue with the ID of the request that queued it.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// validRequestID reports whether id is short and only has printable ASCII in
+// it, so it can't forge log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
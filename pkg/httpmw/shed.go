@@ -0,0 +1,113 @@
+package httpmw
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxShedRetryAfter caps the Retry-After of shed requests, so clients come
+// back to check even during a long overload.
+const maxShedRetryAfter = 30 * time.Second
+
+// ConcurrencyLimiter sheds load by turning requests away as soon as too many
+// are in flight, rather than letting them queue up and slow down every
+// request. Its Middleware method is the Middleware that does this.
+type ConcurrencyLimiter struct {
+	limit    atomic.Int64
+	inFlight atomic.Int64
+	shed     atomic.Int64
+	// latency is a moving average of how long the requests let through take,
+	// in nanoseconds
+	latency atomic.Int64
+
+	mu sync.Mutex
+	// windowShed counts the requests turned away in the second starting at
+	// windowStart
+	windowStart time.Time
+	windowShed  int64
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter that lets limit requests
+// be in flight at once. A limit of 0 or less lets every request through.
+func NewConcurrencyLimiter(limit int) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{}
+	l.SetLimit(limit)
+	return l
+}
+
+// SetLimit changes how many requests can be in flight at once, e.g. when an
+// operator finds the limit is too low to keep up with the traffic. Requests
+// already in flight carry on.
+func (l *ConcurrencyLimiter) SetLimit(limit int) {
+	l.limit.Store(int64(limit))
+}
+
+// Limit returns how many requests can be in flight at once.
+func (l *ConcurrencyLimiter) Limit() int {
+	return int(l.limit.Load())
+}
+
+// InFlight returns how many requests are in flight.
+func (l *ConcurrencyLimiter) InFlight() int {
+	return int(l.inFlight.Load())
+}
+
+// Shed returns how many requests have been turned away.
+func (l *ConcurrencyLimiter) Shed() int64 {
+	return l.shed.Load()
+}
+
+// Middleware answers requests that arrive while the limit is reached with a
+// 503 Service Unavailable right away, and passes the others on to h. The
+// Retry-After header asks the client to wait for about as long as it would
+// take to get through the requests turned away in the last second at the rate
+// requests are finishing, so clients back off further the more overloaded the
+// server is, from 1 second up to 30.
+func (l *ConcurrencyLimiter) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := l.inFlight.Add(1)
+		defer l.inFlight.Add(-1)
+		if limit := l.limit.Load(); limit > 0 && n > limit {
+			l.shed.Add(1)
+			wait := l.retryAfter(limit, time.Now())
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "server is overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		start := time.Now()
+		h.ServeHTTP(w, r)
+		l.observe(time.Since(start))
+	})
+}
+
+// observe adds the latency of a request to the moving average. Concurrent
+// updates can lose one another, which doesn't matter for an estimate.
+func (l *ConcurrencyLimiter) observe(d time.Duration) {
+	avg := l.latency.Load()
+	if avg == 0 {
+		avg = int64(d)
+	} else {
+		avg += (int64(d) - avg) / 8
+	}
+	l.latency.Store(avg)
+}
+
+// retryAfter estimates how long the requests turned away in the last second,
+// this one included, would take to get through limit at a time.
+func (l *ConcurrencyLimiter) retryAfter(limit int64, now time.Time) time.Duration {
+	l.mu.Lock()
+	if now.Sub(l.windowStart) >= time.Second {
+		l.windowStart = now
+		l.windowShed = 0
+	}
+	l.windowShed++
+	backlog := l.windowShed
+	l.mu.Unlock()
+
+	wait := time.Duration(backlog * l.latency.Load() / limit)
+	return min(max(wait, time.Second), maxShedRetryAfter)
+}
@@ -0,0 +1,337 @@
+package httpmw
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RouteTimeout overrides the timeout for the requests matching Pattern, which
+// is an http.ServeMux pattern such as "POST /uploads/" or "GET /reports/{id}".
+// A Timeout of 0 or less leaves those requests without one.
+type RouteTimeout struct {
+	Pattern string
+	Timeout time.Duration
+}
+
+// Timeout gives every request a deadline of timeout, or of the timeout for the
+// route it matches, taking the place of calling context.WithTimeout in
+// each handler. If the deadline passes before the handler has started its
+// response, the client is sent a 504 Gateway Timeout right away, and anything
+// the handler writes afterwards fails with http.ErrHandlerTimeout. A handler
+// that has already started streaming its response only sees its context
+// canceled. Either way, the request isn't done until the handler returns, so
+// handlers should still give up once their context is done.
+//
+//	httpmw.Timeout(5*time.Second,
+//		httpmw.RouteTimeout{Pattern: "POST /uploads/", Timeout: time.Minute},
+//	)
+//
+// Routes are matched as they would be by an http.ServeMux, and registering the
+// same pattern twice panics, as it does there. Handlers whose responses are
+// meant to stay open, such as event streams, lift the deadline with
+// LiftTimeout once they know they are one.
+func Timeout(timeout time.Duration, routes ...RouteTimeout) Middleware {
+	var mux *http.ServeMux
+	timeouts := make(map[string]time.Duration, len(routes))
+	if len(routes) > 0 {
+		mux = http.NewServeMux()
+		for _, route := range routes {
+			mux.Handle(route.Pattern, http.NotFoundHandler())
+			timeouts[route.Pattern] = route.Timeout
+		}
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := timeout
+			if mux != nil {
+				if _, pattern := mux.Handler(r); pattern != "" {
+					timeout = timeouts[pattern]
+				}
+			}
+			if timeout <= 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			tw := &timeoutWriter{w: w, h: make(http.Header)}
+			ctx := newTimeoutContext(r.Context(), timeout, tw.timeout)
+			defer ctx.release()
+			h.ServeHTTP(tw, r.WithContext(ctx))
+			tw.finish()
+		})
+	}
+}
+
+// LiftTimeout removes the deadline Timeout gave the request with context ctx,
+// for handlers whose responses are meant to stay open, such as event streams,
+// and reports whether it did. It is too late once the deadline has passed.
+// The deadline the caller passed in with Deadline still applies.
+func LiftTimeout(ctx context.Context) bool {
+	c, ok := ctx.Value(timeoutContextKey{}).(*timeoutContext)
+	return ok && c.lift()
+}
+
+type timeoutContextKey struct{}
+
+// timeoutContext is the context Timeout gives requests. Like one made with
+// context.WithTimeout, it reports its deadline and is canceled with
+// context.DeadlineExceeded once it passes, but LiftTimeout can remove the
+// deadline before then. Values are looked up in a context canceled along with
+// it, so context.Cause reports why it was.
+type timeoutContext struct {
+	parent   context.Context
+	values   context.Context
+	deadline time.Time
+	// expired is called when the deadline, or the parent's, passes, before
+	// the context is done, so the 504 is under way before the handler can
+	// return
+	expired func()
+	done    chan struct{}
+	timer   *time.Timer
+	stop    func() bool
+
+	mu          sync.Mutex
+	canceling   bool
+	err         error
+	cancelCause context.CancelCauseFunc
+	lifted      bool
+}
+
+func newTimeoutContext(parent context.Context, timeout time.Duration, expired func()) *timeoutContext {
+	c := &timeoutContext{parent: parent, deadline: time.Now().Add(timeout), expired: expired, done: make(chan struct{})}
+	c.values, c.cancelCause = context.WithCancelCause(parent)
+	c.timer = time.AfterFunc(timeout, func() {
+		c.cancel(context.DeadlineExceeded, true)
+	})
+	c.stop = context.AfterFunc(parent, func() {
+		c.cancel(parent.Err(), false)
+	})
+	return c
+}
+
+func (c *timeoutContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	lifted := c.lifted
+	c.mu.Unlock()
+	parent, ok := c.parent.Deadline()
+	if lifted || ok && parent.Before(c.deadline) {
+		return parent, ok
+	}
+	return c.deadline, true
+}
+
+func (c *timeoutContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *timeoutContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *timeoutContext) Value(key any) any {
+	if key == (timeoutContextKey{}) {
+		return c
+	}
+	return c.values.Value(key)
+}
+
+// cancel cancels c with err, unless it is already canceled, or err is the
+// deadline passing and the deadline has been lifted. The cause is err if the
+// deadline passed, and the parent's otherwise.
+func (c *timeoutContext) cancel(err error, deadline bool) {
+	c.mu.Lock()
+	if c.canceling || deadline && c.lifted {
+		c.mu.Unlock()
+		return
+	}
+	c.canceling = true
+	c.mu.Unlock()
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.expired()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	if deadline {
+		c.cancelCause(err)
+	} else {
+		c.cancelCause(context.Cause(c.parent))
+	}
+	close(c.done)
+}
+
+func (c *timeoutContext) lift() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.canceling {
+		return false
+	}
+	c.lifted = true
+	c.timer.Stop()
+	return true
+}
+
+// release cancels c once the request has been handled.
+func (c *timeoutContext) release() {
+	c.timer.Stop()
+	c.stop()
+	c.cancel(context.Canceled, false)
+}
+
+// timeoutWriter passes the handler's response through to w until the deadline
+// passes, unless the response was already under way by then. The handler gets
+// its own header map, so it can't race with the 504 being written. Unwrap
+// gives http.ResponseController the original, for the deadlines and full
+// duplex it can't provide itself.
+type timeoutWriter struct {
+	w http.ResponseWriter
+	h http.Header
+
+	mu          sync.Mutex
+	wroteHeader bool
+	hijacked    bool
+	timedOut    bool
+	done        bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	// informational responses such as 103 Early Hints can come before the real
+	// one, which can still time out
+	if code < 200 {
+		tw.copyHeader()
+		tw.w.WriteHeader(code)
+		return
+	}
+	tw.writeHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	http.NewResponseController(tw.w).Flush()
+}
+
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	conn, rw, err := http.NewResponseController(tw.w).Hijack()
+	if err == nil {
+		tw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+// writeHeader sends the handler's headers with code. It must be called with mu
+// held.
+func (tw *timeoutWriter) writeHeader(code int) {
+	tw.copyHeader()
+	tw.w.WriteHeader(code)
+	tw.wroteHeader = true
+}
+
+// copyHeader copies the handler's headers to w's. It must be called with mu
+// held.
+func (tw *timeoutWriter) copyHeader() {
+	dst := tw.w.Header()
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+}
+
+// timeout sends a 504, unless the handler has started its response or
+// returned.
+func (tw *timeoutWriter) timeout() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.wroteHeader || tw.hijacked || tw.done {
+		return
+	}
+	tw.timedOut = true
+	// with a length, the client has the whole response once it is flushed,
+	// rather than waiting for the handler to return
+	const body = "request timed out\n"
+	h := tw.w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	h.Set("X-Content-Type-Options", "nosniff")
+	tw.w.WriteHeader(http.StatusGatewayTimeout)
+	io.WriteString(tw.w, body)
+	http.NewResponseController(tw.w).Flush()
+}
+
+// finish completes the response once the handler has returned, sending the
+// headers of a handler that didn't write anything, and the trailers it set
+// after writing its body.
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.done = true
+	switch {
+	case tw.timedOut, tw.hijacked:
+	case !tw.wroteHeader:
+		tw.writeHeader(http.StatusOK)
+	default:
+		dst := tw.w.Header()
+		for k, v := range tw.h {
+			if strings.HasPrefix(k, http.TrailerPrefix) || isDeclaredTrailer(tw.h, k) {
+				dst[k] = v
+			}
+		}
+	}
+}
+
+func isDeclaredTrailer(h http.Header, key string) bool {
+	for _, v := range h.Values("Trailer") {
+		for name := range strings.SplitSeq(v, ",") {
+			if http.CanonicalHeaderKey(strings.TrimSpace(name)) == key {
+				return true
+			}
+		}
+	}
+	return false
+}
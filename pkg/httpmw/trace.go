@@ -0,0 +1,194 @@
+package httpmw
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceContext identifies the trace a request is part of, as propagated
+// between services in the W3C traceparent, tracestate and baggage headers, or
+// in Zipkin's B3 headers.
+type TraceContext struct {
+	// TraceID is the ID of the whole trace, as 32 lowercase hex digits.
+	TraceID string
+	// SpanID is the ID this service's handling of the request goes by, as 16
+	// lowercase hex digits. Requests it makes to other services name it as
+	// their parent.
+	SpanID string
+	// ParentSpanID is the ID of the caller's span, or "" if the request
+	// started the trace.
+	ParentSpanID string
+	// Sampled is the caller's decision to record the trace.
+	Sampled bool
+	// State is the vendor-specific tracestate header, passed on as is.
+	State string
+	// Baggage is the baggage header, passed on as is.
+	Baggage string
+
+	// b3 is set if the trace came in B3 headers, so it is passed on in them as
+	// well
+	b3 bool
+}
+
+// TraceParent returns the traceparent header value that makes a request a
+// child of the span tc describes.
+func (tc TraceContext) TraceParent() string {
+	flags := "00"
+	if tc.Sampled {
+		flags = "01"
+	}
+	return "00-" + tc.TraceID + "-" + tc.SpanID + "-" + flags
+}
+
+type traceKey struct{}
+
+// Trace reads the trace each request is part of from its traceparent header,
+// or its B3 headers, and gives the request a span ID of its own within it.
+// Requests that aren't part of one start a new trace, leaving the decision to
+// record it to whatever traces them next. The trace is stored in the request's
+// context, for TraceFromContext, and passed on to other services by
+// TraceTransport.
+func Trace(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc, ok := parseTraceParent(r.Header.Get("traceparent"))
+		if ok {
+			tc.State = r.Header.Get("tracestate")
+		} else if tc, ok = parseB3(r.Header); !ok {
+			tc = TraceContext{TraceID: randomHex(16)}
+		}
+		tc.Baggage = strings.Join(r.Header.Values("baggage"), ",")
+		tc.SpanID = randomHex(8)
+		h.ServeHTTP(w, r.WithContext(ContextWithTrace(r.Context(), tc)))
+	})
+}
+
+// TraceFromContext returns the trace the request ctx belongs to is part of, or
+// false if it isn't in one.
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(TraceContext)
+	return tc, ok
+}
+
+// ContextWithTrace returns a copy of ctx carrying tc, e.g. for work that is
+// picked up from a queue as part of the trace of the request that queued it.
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, tc)
+}
+
+// TraceTransport passes the trace in the context of each request it sends on
+// to the service it is sent to, in the traceparent, tracestate and baggage
+// headers, and in the B3 header too if the trace came in B3 headers. Requests
+// that already have a traceparent header are sent as they are. A nil rt means
+// http.DefaultTransport:
+//
+//	client := &http.Client{Transport: httpmw.TraceTransport(nil)}
+//	req, _ := http.NewRequestWithContext(r.Context(), "GET", url, nil)
+//	resp, err := client.Do(req)
+func TraceTransport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		tc, ok := TraceFromContext(r.Context())
+		if !ok || r.Header.Get("traceparent") != "" {
+			return rt.RoundTrip(r)
+		}
+		// a RoundTripper mustn't change the request it is given
+		r = r.Clone(r.Context())
+		r.Header.Set("traceparent", tc.TraceParent())
+		if tc.State != "" {
+			r.Header.Set("tracestate", tc.State)
+		}
+		if tc.Baggage != "" {
+			r.Header.Set("baggage", tc.Baggage)
+		}
+		if tc.b3 {
+			sampled := "0"
+			if tc.Sampled {
+				sampled = "1"
+			}
+			r.Header.Set("b3", tc.TraceID+"-"+tc.SpanID+"-"+sampled)
+		}
+		return rt.RoundTrip(r)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// parseTraceParent parses a header such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01". Versions after 00
+// may add fields to the end, which are ignored.
+func parseTraceParent(v string) (TraceContext, bool) {
+	if len(v) < 55 || len(v) > 55 && (v[:2] == "00" || v[55] != '-') {
+		return TraceContext{}, false
+	}
+	version, traceID, parentID, flags := v[:2], v[3:35], v[36:52], v[53:55]
+	if v[2] != '-' || v[35] != '-' || v[52] != '-' || version == "ff" ||
+		!isHex(version) || !isTraceID(traceID) || !isTraceID(parentID) || !isHex(flags) {
+		return TraceContext{}, false
+	}
+	b, _ := hex.DecodeString(flags)
+	return TraceContext{TraceID: traceID, ParentSpanID: parentID, Sampled: b[0]&1 == 1}, true
+}
+
+// parseB3 parses either the single b3 header, such as
+// "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1", or the X-B3-TraceId,
+// X-B3-SpanId and X-B3-Sampled headers. 64-bit trace IDs are padded to 128
+// bits.
+func parseB3(h http.Header) (TraceContext, bool) {
+	traceID, spanID, sampled := h.Get("X-B3-TraceId"), h.Get("X-B3-SpanId"), h.Get("X-B3-Sampled")
+	if h.Get("X-B3-Flags") == "1" {
+		sampled = "1"
+	}
+	if v := h.Get("b3"); v != "" {
+		fields := strings.Split(v, "-")
+		if len(fields) < 2 {
+			return TraceContext{}, false
+		}
+		traceID, spanID, sampled = fields[0], fields[1], ""
+		if len(fields) > 2 {
+			sampled = fields[2]
+		}
+	}
+	if len(traceID) == 16 {
+		traceID = "0000000000000000" + traceID
+	}
+	traceID, spanID = strings.ToLower(traceID), strings.ToLower(spanID)
+	if len(traceID) != 32 || len(spanID) != 16 || !isTraceID(traceID) || !isTraceID(spanID) {
+		return TraceContext{}, false
+	}
+	return TraceContext{
+		TraceID:      traceID,
+		ParentSpanID: spanID,
+		Sampled:      sampled == "1" || sampled == "d" || sampled == "true",
+		b3:           true,
+	}, true
+}
+
+// isTraceID reports whether id is lowercase hex and not all zeros, which
+// both formats treat as invalid.
+func isTraceID(id string) bool {
+	return isHex(id) && strings.Trim(id, "0") != ""
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
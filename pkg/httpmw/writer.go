@@ -0,0 +1,74 @@
+package httpmw
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// ResponseWriter wraps an http.ResponseWriter to record the response's status
+// and size, for middleware that reports on responses. It passes Flush and
+// Hijack through, and Unwrap gives http.ResponseController the original.
+type ResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+// NewResponseWriter wraps w, unless it already is a *ResponseWriter.
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	if rw, ok := w.(*ResponseWriter); ok {
+		return rw
+	}
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+func (w *ResponseWriter) WriteHeader(code int) {
+	// informational responses such as 103 Early Hints can come before the real
+	// one
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *ResponseWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	// whatever is sent over the connection now is up to the handler, which is
+	// usually switching to another protocol such as WebSocket
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the status code of the response, or 0 if it hasn't been
+// started. A hijacked connection counts as 101 Switching Protocols.
+func (w *ResponseWriter) Status() int {
+	return w.status
+}
+
+// Written returns the number of bytes of the body written so far.
+func (w *ResponseWriter) Written() int64 {
+	return w.written
+}
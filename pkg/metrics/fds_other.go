@@ -0,0 +1,9 @@
+//go:build !unix
+
+package metrics
+
+// fileDescriptors returns -1 for both, since there are no file descriptors to
+// count here, such as on Windows, whose processes have handles instead.
+func fileDescriptors() (open, max int) {
+	return -1, -1
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package metrics
+
+import (
+	"math"
+	"os"
+	"syscall"
+)
+
+// fileDescriptors returns how many file descriptors the process has open,
+// counted in /proc/self/fd or /dev/fd, and its soft limit on them.
+func fileDescriptors() (open, max int) {
+	open, max = -1, -1
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		entries, err := os.ReadDir(dir)
+		if err == nil {
+			// reading the directory takes a descriptor of its own
+			open = len(entries) - 1
+			break
+		}
+	}
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err == nil {
+		max = rlimitToInt(rl.Cur)
+	}
+	return open, max
+}
+
+// rlimitToInt converts a limit, which is signed on some systems such as
+// FreeBSD and unsigned on others, to an int, or -1 if it doesn't fit, such as
+// RLIM_INFINITY on Linux.
+func rlimitToInt[T int64 | uint64](v T) int {
+	if v < 0 || uint64(v) > math.MaxInt {
+		return -1
+	}
+	return int(v)
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Family is a metric family as a Collector wrote it: the samples that follow
+// its HELP and TYPE lines.
+type Family struct {
+	Name string
+	Help string
+	// Type is the Prometheus type, such as "counter", "gauge" or "histogram",
+	// or "untyped" if the family had no TYPE line.
+	Type    string
+	Samples []Sample
+}
+
+// Sample is a single value of a family. Name is the family's name, with a
+// suffix such as _bucket, _sum or _count for the parts of a histogram.
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Gather collects c's metrics and parses them back into families, in the order
+// they were written, so they can be handed to other metrics systems, such as
+// an OpenTelemetry exporter, without defining them twice.
+func Gather(c Collector) ([]Family, error) {
+	var buf bytes.Buffer
+	c.WriteMetrics(&buf)
+
+	var families []Family
+	var cur *Family
+	// family returns the family called name, starting it if the last one was
+	// called something else
+	family := func(name string) *Family {
+		if cur == nil || cur.Name != name {
+			families = append(families, Family{Name: name, Type: "untyped"})
+			cur = &families[len(families)-1]
+		}
+		return cur
+	}
+	sc := bufio.NewScanner(&buf)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# HELP "):
+			name, help, _ := strings.Cut(line[len("# HELP "):], " ")
+			family(name).Help = help
+		case strings.HasPrefix(line, "# TYPE "):
+			name, typ, _ := strings.Cut(line[len("# TYPE "):], " ")
+			family(name).Type = typ
+		case strings.HasPrefix(line, "#"):
+		default:
+			s, err := parseSample(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if cur == nil || !belongsTo(s.Name, cur) {
+				family(s.Name)
+			}
+			cur.Samples = append(cur.Samples, s)
+		}
+	}
+	return families, sc.Err()
+}
+
+// belongsTo reports whether a sample called name is part of f.
+func belongsTo(name string, f *Family) bool {
+	if name == f.Name {
+		return true
+	}
+	if f.Type != "histogram" && f.Type != "summary" {
+		return false
+	}
+	suffix, ok := strings.CutPrefix(name, f.Name)
+	return ok && (suffix == "_bucket" || suffix == "_sum" || suffix == "_count")
+}
+
+// parseSample parses a line like name{label="value",...} 1.5, ignoring a
+// timestamp after the value.
+func parseSample(line string) (Sample, error) {
+	s := Sample{Labels: map[string]string{}}
+	i := strings.IndexAny(line, "{ ")
+	if i <= 0 {
+		return s, fmt.Errorf("malformed sample %q", line)
+	}
+	s.Name = line[:i]
+	rest := line[i:]
+	if rest[0] == '{' {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimLeft(rest, " ,")
+			if strings.HasPrefix(rest, "}") {
+				rest = rest[1:]
+				break
+			}
+			key, after, ok := strings.Cut(rest, "=")
+			if !ok {
+				return s, fmt.Errorf("malformed labels in %q", line)
+			}
+			value, after, ok := unquoteLabel(after)
+			if !ok {
+				return s, fmt.Errorf("malformed label %s in %q", key, line)
+			}
+			s.Labels[strings.TrimSpace(key)] = value
+			rest = after
+		}
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("missing value in %q", line)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("malformed value in %q", line)
+	}
+	s.Value = v
+	return s, nil
+}
+
+// unquoteLabel parses the quoted label value at the start of s, escaped as
+// QuoteLabel escapes it, and returns it along with the rest of s.
+func unquoteLabel(s string) (value, rest string, ok bool) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", false
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], true
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", false
+			}
+			switch s[i] {
+			case '\\', '"':
+				b.WriteByte(s[i])
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				return "", "", false
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,121 @@
+// Package metrics collects the metrics of a service into one registry and
+// serves them in the Prometheus text format, so a single scrape of /metrics
+// covers the daemon, its servers, its health checks and whatever the
+// application adds.
+//
+// Anything that can write its metrics in the text format is a Collector. The
+// health registry, httpmw.RequestMetrics and breaker.Set already are, so they
+// can be registered as they are:
+//
+//	reg := metrics.NewRegistry()
+//	reg.Register(breakers)
+//	reg.GaugeFunc("queue_depth", "Jobs waiting to be processed.", func() float64 {
+//		return float64(queue.Len())
+//	})
+//	http.Handle("/metrics", reg.Handler())
+//
+// The daemon creates one of these for its internal server, available from
+// Daemon.Metrics.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Collector writes metrics in the Prometheus text format. Each metric family
+// should be written by only one of the collectors in a registry.
+type Collector interface {
+	WriteMetrics(w io.Writer)
+}
+
+// CollectorFunc adapts a function to a Collector.
+type CollectorFunc func(w io.Writer)
+
+// WriteMetrics calls f(w).
+func (f CollectorFunc) WriteMetrics(w io.Writer) {
+	f(w)
+}
+
+// Registry holds the collectors whose metrics are served together. It is
+// itself a Collector, so registries can be nested.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds c to the registry. Collectors are written in the order they
+// were registered.
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// GaugeFunc registers a gauge called name, described by help, whose value is
+// whatever fn returns at the time of each scrape.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.Register(valueFunc(name, help, "gauge", fn))
+}
+
+// CounterFunc registers a counter called name, described by help, whose value
+// is whatever fn returns at the time of each scrape. fn must never return less
+// than it did before.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.Register(valueFunc(name, help, "counter", fn))
+}
+
+func valueFunc(name, help, kind string, fn func() float64) Collector {
+	return CollectorFunc(func(w io.Writer) {
+		WriteHeader(w, name, kind, help)
+		fmt.Fprintf(w, "%s %g\n", name, fn())
+	})
+}
+
+// WriteHeader writes the HELP and TYPE lines that start the metric family
+// called name, for collectors that write their own samples. kind is the
+// Prometheus type, such as "counter", "gauge" or "histogram".
+func WriteHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// labelEscaper escapes what the Prometheus text format requires in label
+// values, which is only backslashes, double quotes and newlines.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// QuoteLabel returns v as a quoted label value, escaped as the Prometheus text
+// format requires rather than as Go's %q does, whose escapes such as \t and
+// \u00e9 would make the whole scrape fail to parse:
+//
+//	fmt.Fprintf(w, "jobs_total{queue=%s} %d\n", metrics.QuoteLabel(name), n)
+func QuoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// WriteMetrics writes the metrics of every collector in the registry to w.
+func (r *Registry) WriteMetrics(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.WriteMetrics(w)
+	}
+}
+
+// Handler returns an http.Handler that serves the metrics of every collector
+// in the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteMetrics(w)
+	})
+}
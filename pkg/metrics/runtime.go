@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime/metrics"
+	"time"
+)
+
+// processStart is about when the process started, since packages are
+// initialized before main runs.
+var processStart = time.Now()
+
+// GCPauseBuckets are the upper bounds of the buckets garbage collection pauses
+// are counted in.
+var GCPauseBuckets = []time.Duration{
+	10 * time.Microsecond,
+	25 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	100 * time.Millisecond,
+}
+
+// RuntimeStats is a snapshot of the Go runtime and the process it runs.
+type RuntimeStats struct {
+	Goroutines int
+	// HeapAlloc is the memory held by heap objects, live or not yet swept.
+	HeapAlloc   uint64
+	HeapObjects uint64
+	// NextGC is the heap size the next garbage collection aims for.
+	NextGC uint64
+	// Sys is all the memory the runtime has mapped.
+	Sys uint64
+	// TotalAlloc is the memory allocated on the heap since the process
+	// started, including what has since been freed.
+	TotalAlloc uint64
+	GCCycles   uint64
+	// GCPauses[i] is the number of stop-the-world pauses for garbage
+	// collection that took at most GCPauseBuckets[i]. The counts are
+	// cumulative, as in a Prometheus histogram.
+	GCPauses     []uint64
+	GCPauseCount uint64
+	// GCPauseTotal is the time spent in those pauses, estimated from the
+	// runtime's finer buckets.
+	GCPauseTotal time.Duration
+	// OpenFDs and MaxFDs are the file descriptors the process has open and
+	// the most it may have, or -1 where that isn't known.
+	OpenFDs int
+	MaxFDs  int
+	Uptime  time.Duration
+}
+
+// ReadRuntime returns the current RuntimeStats. Unlike runtime.ReadMemStats it
+// doesn't stop the world, so it is cheap enough to call on every scrape.
+func ReadRuntime() RuntimeStats {
+	samples := []metrics.Sample{
+		{Name: "/sched/goroutines:goroutines"},
+		{Name: "/memory/classes/heap/objects:bytes"},
+		{Name: "/gc/heap/objects:objects"},
+		{Name: "/gc/heap/goal:bytes"},
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/gc/heap/allocs:bytes"},
+		{Name: "/gc/cycles/total:gc-cycles"},
+		{Name: "/sched/pauses/total/gc:seconds"},
+	}
+	metrics.Read(samples)
+	s := RuntimeStats{
+		Goroutines:  int(samples[0].Value.Uint64()),
+		HeapAlloc:   samples[1].Value.Uint64(),
+		HeapObjects: samples[2].Value.Uint64(),
+		NextGC:      samples[3].Value.Uint64(),
+		Sys:         samples[4].Value.Uint64(),
+		TotalAlloc:  samples[5].Value.Uint64(),
+		GCCycles:    samples[6].Value.Uint64(),
+		GCPauses:    make([]uint64, len(GCPauseBuckets)),
+		Uptime:      time.Since(processStart),
+	}
+	if samples[7].Value.Kind() == metrics.KindFloat64Histogram {
+		s.addPauses(samples[7].Value.Float64Histogram())
+	}
+	s.OpenFDs, s.MaxFDs = fileDescriptors()
+	return s
+}
+
+// addPauses folds the runtime's histogram of pauses, whose Buckets are the
+// boundaries around each of its Counts, into GCPauses.
+func (s *RuntimeStats) addPauses(h *metrics.Float64Histogram) {
+	var total float64
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+		lo, hi := h.Buckets[i], h.Buckets[i+1]
+		s.GCPauseCount += n
+		switch {
+		case math.IsInf(lo, -1):
+			total += float64(n) * hi
+		case math.IsInf(hi, 1):
+			total += float64(n) * lo
+		default:
+			total += float64(n) * (lo + hi) / 2
+		}
+		// the bucket counts towards every bound it lies entirely below
+		for j, bound := range GCPauseBuckets {
+			if hi <= bound.Seconds() {
+				s.GCPauses[j] += n
+			}
+		}
+	}
+	s.GCPauseTotal = time.Duration(total * float64(time.Second))
+}
+
+// RuntimeCollector returns a Collector that writes ReadRuntime's stats under
+// the names Prometheus's Go client uses for them where there is one, such as
+// go_goroutines and process_open_fds, along with process_uptime_seconds and a
+// go_gc_pause_seconds histogram. The daemon registers one for every daemon.
+func RuntimeCollector() Collector {
+	return CollectorFunc(func(w io.Writer) {
+		s := ReadRuntime()
+
+		WriteHeader(w, "go_goroutines", "gauge", "Goroutines that currently exist.")
+		fmt.Fprintf(w, "go_goroutines %d\n", s.Goroutines)
+		WriteHeader(w, "go_memstats_heap_alloc_bytes", "gauge", "Bytes held by heap objects, live or not yet swept.")
+		fmt.Fprintf(w, "go_memstats_heap_alloc_bytes %d\n", s.HeapAlloc)
+		WriteHeader(w, "go_memstats_heap_objects", "gauge", "Objects on the heap, live or not yet swept.")
+		fmt.Fprintf(w, "go_memstats_heap_objects %d\n", s.HeapObjects)
+		WriteHeader(w, "go_memstats_next_gc_bytes", "gauge", "Heap size the next garbage collection aims for.")
+		fmt.Fprintf(w, "go_memstats_next_gc_bytes %d\n", s.NextGC)
+		WriteHeader(w, "go_memstats_sys_bytes", "gauge", "Bytes mapped by the Go runtime.")
+		fmt.Fprintf(w, "go_memstats_sys_bytes %d\n", s.Sys)
+		WriteHeader(w, "go_memstats_alloc_bytes_total", "counter", "Bytes allocated on the heap, including what has been freed.")
+		fmt.Fprintf(w, "go_memstats_alloc_bytes_total %d\n", s.TotalAlloc)
+		WriteHeader(w, "go_gc_cycles_total", "counter", "Completed garbage collection cycles.")
+		fmt.Fprintf(w, "go_gc_cycles_total %d\n", s.GCCycles)
+
+		WriteHeader(w, "go_gc_pause_seconds", "histogram", "Stop-the-world pauses for garbage collection.")
+		for i, bound := range GCPauseBuckets {
+			fmt.Fprintf(w, "go_gc_pause_seconds_bucket{le=\"%g\"} %d\n", bound.Seconds(), s.GCPauses[i])
+		}
+		fmt.Fprintf(w, "go_gc_pause_seconds_bucket{le=\"+Inf\"} %d\n", s.GCPauseCount)
+		fmt.Fprintf(w, "go_gc_pause_seconds_sum %g\n", s.GCPauseTotal.Seconds())
+		fmt.Fprintf(w, "go_gc_pause_seconds_count %d\n", s.GCPauseCount)
+
+		if s.OpenFDs >= 0 {
+			WriteHeader(w, "process_open_fds", "gauge", "Open file descriptors.")
+			fmt.Fprintf(w, "process_open_fds %d\n", s.OpenFDs)
+		}
+		if s.MaxFDs >= 0 {
+			WriteHeader(w, "process_max_fds", "gauge", "The most file descriptors the process may open.")
+			fmt.Fprintf(w, "process_max_fds %d\n", s.MaxFDs)
+		}
+		WriteHeader(w, "process_start_time_seconds", "gauge", "When the process started, in seconds since the Unix epoch.")
+		fmt.Fprintf(w, "process_start_time_seconds %g\n", float64(processStart.UnixNano())/1e9)
+		WriteHeader(w, "process_uptime_seconds", "gauge", "How long the process has been running.")
+		fmt.Fprintf(w, "process_uptime_seconds %g\n", s.Uptime.Seconds())
+	})
+}
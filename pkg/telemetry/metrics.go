@@ -0,0 +1,228 @@
+package telemetry
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/forgeutah/utah-go/pkg/metrics"
+)
+
+// WithExportInterval sets how often NewMeterProvider pushes the metrics. It
+// defaults to $OTEL_METRIC_EXPORT_INTERVAL, or a minute.
+func WithExportInterval(interval time.Duration) Option {
+	return func(c *config) {
+		c.exportInterval = interval
+	}
+}
+
+// WithMetricExporter pushes metrics to exp instead of the OTLP/HTTP exporter,
+// e.g. to export them over gRPC.
+func WithMetricExporter(exp sdkmetric.Exporter) Option {
+	return func(c *config) {
+		c.metricExporter = exp
+	}
+}
+
+// NewMeterProvider returns a MeterProvider that pushes the metrics in reg over
+// OTLP/HTTP every export interval, configured by the OTEL_EXPORTER_OTLP_*
+// environment variables, for environments where nothing scrapes /metrics. The
+// metrics are the ones reg serves, so the daemon's registry is exported as is:
+//
+//	mp, err := telemetry.NewMeterProvider(ctx, d.Metrics())
+//	if err != nil {
+//		return err
+//	}
+//	d.OnShutdown("meter provider", mp.Shutdown)
+//
+// It also becomes the global MeterProvider, so instruments created with the
+// OpenTelemetry API are pushed alongside them. Its Shutdown method pushes the
+// metrics one last time.
+func NewMeterProvider(ctx context.Context, reg metrics.Collector, opts ...Option) (*sdkmetric.MeterProvider, error) {
+	c := newConfig(opts)
+	res, err := c.resource(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("describing the service: %w", err)
+	}
+	exp := c.metricExporter
+	if exp == nil {
+		if exp, err = otlpmetrichttp.New(ctx); err != nil {
+			return nil, fmt.Errorf("creating the OTLP exporter: %w", err)
+		}
+	}
+	var readerOpts []sdkmetric.PeriodicReaderOption
+	if c.exportInterval > 0 {
+		readerOpts = append(readerOpts, sdkmetric.WithInterval(c.exportInterval))
+	}
+	readerOpts = append(readerOpts, sdkmetric.WithProducer(&registryProducer{reg: reg, start: time.Now()}))
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp, readerOpts...)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(mp)
+	return mp, nil
+}
+
+// registryProducer turns the metrics a collector writes in the Prometheus text
+// format into OpenTelemetry metrics. Counters become monotonic sums, gauges
+// and untyped metrics gauges, and histograms explicit bucket histograms, all
+// cumulative since start.
+type registryProducer struct {
+	reg   metrics.Collector
+	start time.Time
+}
+
+func (p *registryProducer) Produce(ctx context.Context) ([]metricdata.ScopeMetrics, error) {
+	families, err := metrics.Gather(p.reg)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sm := metricdata.ScopeMetrics{
+		Scope: instrumentation.Scope{Name: "github.com/forgeutah/utah-go/pkg/metrics"},
+	}
+	for _, f := range families {
+		m := metricdata.Metrics{Name: f.Name, Description: f.Help}
+		switch f.Type {
+		case "counter":
+			m.Data = metricdata.Sum[float64]{
+				Temporality: metricdata.CumulativeTemporality,
+				IsMonotonic: true,
+				DataPoints:  p.points(f.Samples, now),
+			}
+		case "histogram":
+			m.Data = metricdata.Histogram[float64]{
+				Temporality: metricdata.CumulativeTemporality,
+				DataPoints:  p.histogramPoints(f, now),
+			}
+		case "summary":
+			// the quantiles can't be aggregated, so there is nothing
+			// faithful to turn them into
+			continue
+		default:
+			m.Data = metricdata.Gauge[float64]{DataPoints: p.points(f.Samples, now)}
+		}
+		sm.Metrics = append(sm.Metrics, m)
+	}
+	return []metricdata.ScopeMetrics{sm}, nil
+}
+
+func (p *registryProducer) points(samples []metrics.Sample, now time.Time) []metricdata.DataPoint[float64] {
+	points := make([]metricdata.DataPoint[float64], 0, len(samples))
+	for _, s := range samples {
+		points = append(points, metricdata.DataPoint[float64]{
+			Attributes: attributes(s.Labels, ""),
+			StartTime:  p.start,
+			Time:       now,
+			Value:      s.Value,
+		})
+	}
+	return points
+}
+
+// histogramPoints groups the _bucket, _sum and _count samples of f by their
+// labels other than le.
+func (p *registryProducer) histogramPoints(f metrics.Family, now time.Time) []metricdata.HistogramDataPoint[float64] {
+	type bucket struct {
+		bound      float64
+		cumulative uint64
+	}
+	type series struct {
+		labels  map[string]string
+		buckets []bucket
+		sum     float64
+		count   uint64
+	}
+	var order []string
+	all := map[string]*series{}
+	for _, s := range f.Samples {
+		key := seriesKey(s.Labels)
+		sr, ok := all[key]
+		if !ok {
+			sr = &series{labels: s.Labels}
+			all[key] = sr
+			order = append(order, key)
+		}
+		switch strings.TrimPrefix(s.Name, f.Name) {
+		case "_bucket":
+			bound, err := strconv.ParseFloat(s.Labels["le"], 64)
+			if err != nil || math.IsInf(bound, 1) {
+				continue
+			}
+			sr.buckets = append(sr.buckets, bucket{bound, uint64(s.Value)})
+		case "_sum":
+			sr.sum = s.Value
+		case "_count":
+			sr.count = uint64(s.Value)
+		}
+	}
+
+	points := make([]metricdata.HistogramDataPoint[float64], 0, len(order))
+	for _, key := range order {
+		sr := all[key]
+		slices.SortFunc(sr.buckets, func(a, b bucket) int {
+			return cmp.Compare(a.bound, b.bound)
+		})
+		// Prometheus buckets count everything up to their bound, while
+		// OpenTelemetry ones count only what falls in between
+		bounds := make([]float64, len(sr.buckets))
+		counts := make([]uint64, len(sr.buckets)+1)
+		var prev uint64
+		for i, b := range sr.buckets {
+			bounds[i] = b.bound
+			counts[i] = b.cumulative - min(prev, b.cumulative)
+			prev = b.cumulative
+		}
+		counts[len(sr.buckets)] = sr.count - min(prev, sr.count)
+		points = append(points, metricdata.HistogramDataPoint[float64]{
+			Attributes:   attributes(sr.labels, "le"),
+			StartTime:    p.start,
+			Time:         now,
+			Count:        sr.count,
+			Bounds:       bounds,
+			BucketCounts: counts,
+			Sum:          sr.sum,
+		})
+	}
+	return points
+}
+
+// attributes turns labels, other than skip, into attributes.
+func attributes(labels map[string]string, skip string) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(labels))
+	for k, v := range labels {
+		if k != skip {
+			kvs = append(kvs, attribute.String(k, v))
+		}
+	}
+	return attribute.NewSet(kvs...)
+}
+
+// seriesKey identifies a histogram series by its labels other than le.
+func seriesKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		if k != "le" {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(strconv.Quote(k))
+		b.WriteString(strconv.Quote(labels[k]))
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+// Package telemetry sets up OpenTelemetry for services run by the daemon, so
+// each one doesn't have to wire up the SDK, the OTLP exporter and the HTTP
+// instrumentation itself.
+//
+// NewTracerProvider exports spans over OTLP/HTTP to the collector named by the
+// standard OTEL_EXPORTER_OTLP_ENDPOINT environment variable, and WithTracing
+// makes the daemon's servers start a span for every request and flush the
+// spans still buffered when the daemon shuts down:
+//
+//	tp, err := telemetry.NewTracerProvider(ctx, telemetry.WithServiceName("orders"))
+//	if err != nil {
+//		return err
+//	}
+//	d := daemon.New(mux, telemetry.WithTracing(tp))
+//
+// Requests to other services made through Transport get client spans of their
+// own, and carry the trace on to them.
+//
+// Where nothing scrapes the internal server's /metrics, NewMeterProvider
+// pushes the same metrics over OTLP instead.
+//
+// The spans belong to the same trace the httpmw package's Trace middleware
+// puts in each request's context, so the trace IDs in the access log lead
+// straight to the spans, even for requests that started a trace or came in
+// with B3 headers.
+package telemetry
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Option configures NewTracerProvider and NewMeterProvider.
+type Option func(*config)
+
+type config struct {
+	serviceName    string
+	serviceVersion string
+	sampleRatio    float64
+	spanExporter   sdktrace.SpanExporter
+	exportInterval time.Duration
+	metricExporter sdkmetric.Exporter
+}
+
+// WithServiceName sets the service.name the telemetry is reported under. It
+// defaults to $OTEL_SERVICE_NAME, or unknown_service: followed by the name of
+// the executable.
+func WithServiceName(name string) Option {
+	return func(c *config) {
+		c.serviceName = name
+	}
+}
+
+// WithServiceVersion sets the service.version the telemetry is reported
+// under. It defaults to $APP_VERSION, like the daemon's version.
+func WithServiceVersion(version string) Option {
+	return func(c *config) {
+		c.serviceVersion = version
+	}
+}
+
+func newConfig(opts []Option) config {
+	c := config{
+		serviceVersion: os.Getenv("APP_VERSION"),
+		sampleRatio:    1,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// resource describes the service the telemetry comes from, along with the
+// attributes in $OTEL_RESOURCE_ATTRIBUTES.
+func (c config) resource(ctx context.Context) (*resource.Resource, error) {
+	var attrs []attribute.KeyValue
+	if c.serviceName != "" {
+		attrs = append(attrs, attribute.String("service.name", c.serviceName))
+	}
+	if c.serviceVersion != "" {
+		attrs = append(attrs, attribute.String("service.version", c.serviceVersion))
+	}
+	// later detectors win, so the options win over the environment
+	r, err := resource.New(ctx,
+		resource.WithHost(),
+		resource.WithFromEnv(),
+		resource.WithAttributes(attrs...),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return resource.Merge(resource.Default(), r)
+}
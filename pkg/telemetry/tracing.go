@@ -0,0 +1,197 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/forgeutah/utah-go/pkg/daemon"
+	"github.com/forgeutah/utah-go/pkg/httpmw"
+)
+
+// WithSpanExporter sends spans to exp instead of the OTLP/HTTP exporter, e.g.
+// to export them over gRPC or print them while developing.
+func WithSpanExporter(exp sdktrace.SpanExporter) Option {
+	return func(c *config) {
+		c.spanExporter = exp
+	}
+}
+
+// WithSampleRatio records the given fraction of the traces this service
+// starts, from 0 to 1. Traces started by a caller are recorded if the caller
+// recorded them. It defaults to recording every trace.
+func WithSampleRatio(ratio float64) Option {
+	return func(c *config) {
+		c.sampleRatio = ratio
+	}
+}
+
+// NewTracerProvider returns a TracerProvider that batches spans up and exports
+// them over OTLP/HTTP, configured by the OTEL_EXPORTER_OTLP_* environment
+// variables, and makes it the global one along with the W3C trace context and
+// baggage propagators. Its Shutdown method flushes the spans still buffered,
+// which WithTracing arranges for when the daemon shuts down.
+func NewTracerProvider(ctx context.Context, opts ...Option) (*sdktrace.TracerProvider, error) {
+	c := newConfig(opts)
+	res, err := c.resource(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("describing the service: %w", err)
+	}
+	exp := c.spanExporter
+	if exp == nil {
+		if exp, err = otlptracehttp.New(ctx); err != nil {
+			return nil, fmt.Errorf("creating the OTLP exporter: %w", err)
+		}
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.sampleRatio))),
+		sdktrace.WithIDGenerator(idGenerator{}),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp, nil
+}
+
+// WithTracing makes the daemon's servers, other than the internal one, start
+// a span with tp for every request, and flushes tp when the daemon shuts down,
+// after every other shutdown hook has run.
+func WithTracing(tp *sdktrace.TracerProvider) daemon.Option {
+	return func(d *daemon.Daemon) {
+		daemon.WithMiddleware(handler(tp))(d)
+		d.OnShutdown("tracer provider", tp.Shutdown)
+	}
+}
+
+// Handler starts a server span with the global TracerProvider for every
+// request h serves, as a child of the caller's span, and named after the
+// method and the route that matched, such as "GET /orders/{id}", if
+// httpmw.CaptureRoute or the mux reports it. The request's httpmw trace
+// context is updated to the span, so httpmw.TraceTransport makes the span the
+// parent of the requests made while handling it.
+func Handler(h http.Handler) http.Handler {
+	return handler(nil)(h)
+}
+
+func handler(tp trace.TracerProvider) httpmw.Middleware {
+	var opts []otelhttp.Option
+	if tp != nil {
+		opts = append(opts, otelhttp.WithTracerProvider(tp))
+	}
+	opts = append(opts, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method
+	}))
+	return func(h http.Handler) http.Handler {
+		inner := otelhttp.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			span := trace.SpanFromContext(r.Context())
+			ctx := r.Context()
+			// without a TracerProvider set up, the span is the caller's or none
+			sc := span.SpanContext()
+			if tc, ok := httpmw.TraceFromContext(ctx); ok && sc.IsValid() && !sc.IsRemote() {
+				tc.TraceID = sc.TraceID().String()
+				tc.SpanID = sc.SpanID().String()
+				tc.Sampled = sc.IsSampled()
+				ctx = httpmw.ContextWithTrace(ctx, tc)
+			}
+			if route := routePath(httpmw.ServeRoute(h, w, r.WithContext(ctx))); route != "" {
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(attribute.String("http.route", route))
+			}
+		}), "", opts...)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inner.ServeHTTP(w, r.WithContext(withRemoteParent(r.Context())))
+		})
+	}
+}
+
+// withRemoteParent makes the caller's span named in ctx's httpmw trace context
+// the parent of the spans started with ctx, so callers that sent B3 headers,
+// which the OpenTelemetry propagators don't read, are still the parent.
+func withRemoteParent(ctx context.Context) context.Context {
+	tc, ok := httpmw.TraceFromContext(ctx)
+	if !ok || tc.ParentSpanID == "" {
+		return ctx
+	}
+	traceID, err := trace.TraceIDFromHex(tc.TraceID)
+	if err != nil {
+		return ctx
+	}
+	spanID, err := trace.SpanIDFromHex(tc.ParentSpanID)
+	if err != nil {
+		return ctx
+	}
+	var flags trace.TraceFlags
+	if tc.Sampled {
+		flags = trace.FlagsSampled
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: flags,
+		Remote:     true,
+	}))
+}
+
+// routePath returns the path of a ServeMux pattern, such as /orders/{id} for
+// "GET example.com/orders/{id}".
+func routePath(pattern string) string {
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		pattern = pattern[i+1:]
+	}
+	if i := strings.IndexByte(pattern, '/'); i >= 0 {
+		return pattern[i:]
+	}
+	return ""
+}
+
+// Transport returns an http.RoundTripper that starts a client span for every
+// request it sends through rt, or http.DefaultTransport if rt is nil, as a
+// child of the span in the request's context, and passes the trace on to the
+// server in the traceparent header. Give it to httpclient.WithTransport so the
+// retries and hedged requests show up as spans of their own.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(rt)
+}
+
+// idGenerator starts new traces with the trace ID httpmw.Trace already gave
+// the request, if there is one, so the trace IDs it logs match the spans.
+type idGenerator struct{}
+
+func (idGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if tc, ok := httpmw.TraceFromContext(ctx); ok {
+		if traceID, err := trace.TraceIDFromHex(tc.TraceID); err == nil {
+			return traceID, newSpanID()
+		}
+	}
+	var traceID trace.TraceID
+	for !traceID.IsValid() {
+		rand.Read(traceID[:])
+	}
+	return traceID, newSpanID()
+}
+
+func (idGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	return newSpanID()
+}
+
+func newSpanID() trace.SpanID {
+	var spanID trace.SpanID
+	for !spanID.IsValid() {
+		rand.Read(spanID[:])
+	}
+	return spanID
+}